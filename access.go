@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// parseAllowlist parses a comma-separated list of IPs and CIDRs.
+func parseAllowlist(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid IP %q: %w", entry, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", entry, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// allowed returns whether the client IP of the request is part of the allowlist.
+func allowed(prefixes []netip.Prefix, r *http.Request) bool {
+	addr, err := netip.ParseAddr(clientIP(r))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowIPs only lets the client IPs of the provided list, set using the provided flag, access the routes it is used
+// on, replying with a 403 to the others.
+func allowIPs(flagName, list string) func(http.Handler) http.Handler {
+	prefixes, err := parseAllowlist(list)
+	if err != nil {
+		log.Fatal("unable to parse ", flagName, ": ", err)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !allowed(prefixes, r) {
+				slog.Warn("refused request from IP outside of the allowlist", "flag", flagName, "ip", clientIP(r), "uri", r.URL.Path)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// protectMetrics restricts the access to the metrics listener to the --metrics-allow IPs, and requires the basic
+// auth credentials set in the DRAND_METRICS_BASIC_AUTH env variable as user:password, or the bearer token set in the
+// DRAND_METRICS_TOKEN env variable, if any.
+func protectMetrics(next http.Handler) http.Handler {
+	if *metricsAllow != "" {
+		next = allowIPs("--metrics-allow", *metricsAllow)(next)
+	}
+
+	basic := os.Getenv("DRAND_METRICS_BASIC_AUTH")
+	if basic != "" && !strings.Contains(basic, ":") {
+		log.Fatal("DRAND_METRICS_BASIC_AUTH must be set as user:password")
+	}
+	token := os.Getenv("DRAND_METRICS_TOKEN")
+	if basic == "" && token == "" {
+		return next
+	}
+
+	equal := func(a, b string) bool {
+		return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); ok && basic != "" && equal(user+":"+password, basic) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" && equal(bearer, token) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		slog.Warn("refused unauthenticated metrics request", "ip", clientIP(r), "uri", r.URL.Path)
+		if basic != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="drand-relay-metrics"`)
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// metricsTLSConfig returns the TLS config of the metrics listener, serving the --metrics-tls-cert, reloaded when it
+// changes, and requiring client certificates signed by the --metrics-client-ca when it is set.
+func metricsTLSConfig(ctx context.Context) (*tls.Config, error) {
+	certs, err := newCertReloader(*metricsCert, *metricsKey)
+	if err != nil {
+		return nil, err
+	}
+	go certs.Watch(ctx, certCheckInterval)
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: certs.GetCertificate}
+	if *metricsClientCA == "" {
+		return cfg, nil
+	}
+	if cfg.ClientCAs, err = loadCertPool(*metricsClientCA); err != nil {
+		return nil, fmt.Errorf("invalid --metrics-client-ca: %w", err)
+	}
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return cfg, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drand/drand/v2/common"
+	drandcrypto "github.com/drand/drand/v2/crypto"
+	"github.com/drand/http-relay/local"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAllowlist(t *testing.T) {
+	prefixes, err := parseAllowlist("192.0.2.1, 10.0.0.0/8,,2001:db8::/32 ,::ffff:198.51.100.7")
+	require.NoError(t, err)
+	require.Len(t, prefixes, 4)
+
+	_, err = parseAllowlist("192.0.2.256")
+	require.Error(t, err)
+	_, err = parseAllowlist("10.0.0.0/33")
+	require.Error(t, err)
+
+	allowIP := func(ip string) bool {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = ip
+		return allowed(prefixes, r)
+	}
+	require.True(t, allowIP("192.0.2.1:1234"))
+	require.False(t, allowIP("192.0.2.2:1234"))
+	require.True(t, allowIP("10.1.2.3:1234"))
+	require.True(t, allowIP("[2001:db8::1]:1234"))
+	require.True(t, allowIP("198.51.100.7:1234"))
+	require.True(t, allowIP("[::ffff:10.0.0.1]:1234"))
+	require.False(t, allowIP("not-an-ip"))
+}
+
+func TestSetupRoutes_AccessPolicies(t *testing.T) {
+	secretHex := strings.Repeat("d", 256)
+	t.Setenv("DRAND_AUTH_KEY", secretHex)
+	secret, err := hex.DecodeString(secretHex)
+	require.NoError(t, err)
+	latestOnly, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &scopeClaims{Ops: []string{opLatest}}).SignedString(secret)
+	require.NoError(t, err)
+
+	setFlag(t, requireAuth, true)
+	setFlag(t, v1Auth, true)
+	setFlag(t, v2Allow, "192.0.2.0/24")
+
+	dev, err := local.NewDevChain(drandcrypto.SigsOnG1ID, time.Second, common.DefaultBeaconID)
+	require.NoError(t, err)
+	defer dev.Close()
+
+	r := chi.NewRouter()
+	SetupRoutes(context.Background(), r, dev)
+	call := func(ip, path, token string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// v1 requires the same authentication and scopes as v2
+	require.Equal(t, http.StatusUnauthorized, call("198.51.100.1", "/public/latest", ""))
+	require.Equal(t, http.StatusOK, call("198.51.100.1", "/public/latest", latestOnly))
+	require.Equal(t, http.StatusForbidden, call("198.51.100.1", "/public/1", latestOnly))
+	require.Equal(t, http.StatusOK, call("198.51.100.1", "/info", latestOnly))
+
+	// v2 is only open to its allowlist
+	require.Equal(t, http.StatusForbidden, call("198.51.100.1", "/v2/beacons/default/rounds/latest", latestOnly))
+	require.Equal(t, http.StatusOK, call("192.0.2.1", "/v2/beacons/default/rounds/latest", latestOnly))
+}
+
+func TestProtectMetrics(t *testing.T) {
+	t.Setenv("DRAND_METRICS_BASIC_AUTH", "prom:s3cret")
+	t.Setenv("DRAND_METRICS_TOKEN", "token")
+	setFlag(t, metricsAllow, "127.0.0.1")
+
+	h := protectMetrics(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	call := func(ip string, auth func(r *http.Request)) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		r.RemoteAddr = ip + ":1234"
+		auth(r)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	none := func(*http.Request) {}
+	basic := func(user, password string) func(*http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(user, password) }
+	}
+	bearer := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	w := call("127.0.0.1", none)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Contains(t, w.Header().Get("WWW-Authenticate"), "Basic")
+	require.Equal(t, http.StatusUnauthorized, call("127.0.0.1", basic("prom", "wrong")).Code)
+	require.Equal(t, http.StatusUnauthorized, call("127.0.0.1", bearer("wrong")).Code)
+	require.Equal(t, http.StatusOK, call("127.0.0.1", basic("prom", "s3cret")).Code)
+	require.Equal(t, http.StatusOK, call("127.0.0.1", bearer("token")).Code)
+	require.Equal(t, http.StatusForbidden, call("192.0.2.1", bearer("token")).Code)
+}
@@ -0,0 +1,194 @@
+// Package apikeys implements the static API keys accepted by the relay as an alternative to JWTs, for the clients that
+// cannot handle them. Keys are stored as salted hashes in a local file, along with their owner, expiry and scopes, so
+// that leaking the file doesn't leak the keys.
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidKey is returned for keys that are malformed, unknown or not matching their hash.
+	ErrInvalidKey = errors.New("invalid API key")
+	// ErrExpiredKey is returned for keys past their expiry.
+	ErrExpiredKey = errors.New("expired API key")
+)
+
+// Key is an entry of the API keys file. The scopes have the same meaning as the relay JWT claims: a missing or null
+// scope doesn't restrict anything, while an empty list grants nothing.
+type Key struct {
+	ID        string     `json:"id"`
+	Owner     string     `json:"owner"`
+	Salt      string     `json:"salt"`
+	Hash      string     `json:"hash"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Chains    []string   `json:"chains"`
+	BeaconIDs []string   `json:"beacon_ids"`
+	Ops       []string   `json:"ops"`
+	Tier      string     `json:"tier,omitempty"`
+}
+
+// API keys are formatted as <id>.<secret>, the ID allowing us to find the entry to check the secret against.
+const (
+	idSize     = 8
+	secretSize = 32
+	saltSize   = 16
+)
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hash returns the hex-encoded SHA-256 of the salt and secret. Our secrets are random 256 bits values, so we don't
+// need a slow password hashing function.
+func hash(salt, secret string) string {
+	h := sha256.Sum256([]byte(salt + secret))
+	return hex.EncodeToString(h[:])
+}
+
+// New generates a new API key for the owner, returning the key to hand over to the owner and its entry, which only
+// holds its salted hash.
+func New(owner string) (string, Key, error) {
+	id, err := randomHex(idSize)
+	if err != nil {
+		return "", Key{}, err
+	}
+	secret, err := randomHex(secretSize)
+	if err != nil {
+		return "", Key{}, err
+	}
+	salt, err := randomHex(saltSize)
+	if err != nil {
+		return "", Key{}, err
+	}
+	return id + "." + secret, Key{ID: id, Owner: owner, Salt: salt, Hash: hash(salt, secret)}, nil
+}
+
+// Load reads the API keys of the provided file.
+func Load(path string) ([]Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("invalid API keys file %s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// Store holds the API keys of a file, indexed by ID, reloading them when the file changes.
+type Store struct {
+	path string
+
+	mu      sync.RWMutex
+	keys    map[string]Key
+	modTime time.Time
+	size    int64
+}
+
+// NewStore loads the API keys of the provided file.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path}
+	if _, err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the file again if it changed since the last time it was loaded, and returns whether it did. It keeps
+// the current keys if the file cannot be read or is invalid.
+func (s *Store) Reload() (bool, error) {
+	st, err := os.Stat(s.path)
+	if err != nil {
+		return false, fmt.Errorf("unable to read API keys: %w", err)
+	}
+
+	s.mu.RLock()
+	unchanged := st.ModTime().Equal(s.modTime) && st.Size() == s.size
+	s.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	list, err := Load(s.path)
+	if err != nil {
+		return false, err
+	}
+	keys := make(map[string]Key, len(list))
+	for _, k := range list {
+		if k.ID == "" || k.Salt == "" || k.Hash == "" {
+			return false, fmt.Errorf("invalid API keys file %s: all keys must have an id, salt and hash", s.path)
+		}
+		if _, ok := keys[k.ID]; ok {
+			return false, fmt.Errorf("invalid API keys file %s: duplicate key ID %q", s.path, k.ID)
+		}
+		keys[k.ID] = k
+	}
+
+	s.mu.Lock()
+	s.keys, s.modTime, s.size = keys, st.ModTime(), st.Size()
+	s.mu.Unlock()
+	return true, nil
+}
+
+// Watch checks the file for changes every interval until the context is cancelled, logging failures.
+func (s *Store) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := s.Reload()
+			if err != nil {
+				slog.Error("unable to reload API keys, keeping the current ones", "err", err)
+			} else if reloaded {
+				slog.Info("reloaded API keys", "path", s.path, "keys", s.Len())
+			}
+		}
+	}
+}
+
+// Len returns the number of keys in the store.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.keys)
+}
+
+// Verify returns the entry of the provided API key if it is valid at the provided time.
+func (s *Store) Verify(apiKey string, now time.Time) (*Key, error) {
+	id, secret, ok := strings.Cut(apiKey, ".")
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+
+	s.mu.RLock()
+	k, ok := s.keys[id]
+	s.mu.RUnlock()
+	if !ok || subtle.ConstantTimeCompare([]byte(hash(k.Salt, secret)), []byte(k.Hash)) != 1 {
+		return nil, ErrInvalidKey
+	}
+	if k.ExpiresAt != nil && !now.Before(*k.ExpiresAt) {
+		return nil, fmt.Errorf("%w %q", ErrExpiredKey, id)
+	}
+	return &k, nil
+}
@@ -0,0 +1,83 @@
+package apikeys
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeKeys(t *testing.T, path string, keys ...Key) {
+	t.Helper()
+	data, err := json.Marshal(keys)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+}
+
+func TestStoreVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	now := time.Now()
+
+	apiKey, k, err := New("acme")
+	require.NoError(t, err)
+	require.NotContains(t, k.Hash, apiKey)
+	expired, e, err := New("old")
+	require.NoError(t, err)
+	past := now.Add(-time.Minute)
+	e.ExpiresAt = &past
+	writeKeys(t, path, k, e)
+
+	s, err := NewStore(path)
+	require.NoError(t, err)
+	require.Equal(t, 2, s.Len())
+
+	got, err := s.Verify(apiKey, now)
+	require.NoError(t, err)
+	require.Equal(t, "acme", got.Owner)
+
+	_, err = s.Verify(expired, now)
+	require.ErrorIs(t, err, ErrExpiredKey)
+
+	for _, invalid := range []string{"", "nodot", k.ID, k.ID + ".", k.ID + "." + k.Hash, "unknown." + apiKey[len(k.ID)+1:]} {
+		_, err = s.Verify(invalid, now)
+		require.ErrorIs(t, err, ErrInvalidKey, invalid)
+	}
+}
+
+func TestStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	apiKey, k, err := New("acme")
+	require.NoError(t, err)
+	writeKeys(t, path, k)
+
+	s, err := NewStore(path)
+	require.NoError(t, err)
+	reloaded, err := s.Reload()
+	require.NoError(t, err)
+	require.False(t, reloaded)
+
+	// removing a key revokes it
+	other, o, err := New("other")
+	require.NoError(t, err)
+	writeKeys(t, path, o)
+	reloaded, err = s.Reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	_, err = s.Verify(apiKey, time.Now())
+	require.ErrorIs(t, err, ErrInvalidKey)
+	_, err = s.Verify(other, time.Now())
+	require.NoError(t, err)
+
+	// invalid files keep the current keys
+	writeKeys(t, path, o, o)
+	_, err = s.Reload()
+	require.ErrorContains(t, err, "duplicate key ID")
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0o600))
+	_, err = s.Reload()
+	require.Error(t, err)
+	_, err = s.Verify(other, time.Now())
+	require.NoError(t, err)
+}
@@ -0,0 +1,183 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/drand/http-relay/grpc"
+)
+
+// Archive is holding the chain info of a beacon chain along with a set of its beacons, sorted by round.
+type Archive struct {
+	Info    *grpc.JsonInfoV2
+	Beacons []*grpc.HexBeacon
+}
+
+// Beacon returns the archived beacon for the given round, if any.
+func (a *Archive) Beacon(round uint64) (*grpc.HexBeacon, bool) {
+	i, found := slices.BinarySearchFunc(a.Beacons, round, func(b *grpc.HexBeacon, r uint64) int {
+		switch {
+		case b.Round < r:
+			return -1
+		case b.Round > r:
+			return 1
+		}
+		return 0
+	})
+	if !found {
+		return nil, false
+	}
+	return a.Beacons[i], true
+}
+
+// sort makes sure the beacons are sorted by round and refuses duplicated rounds.
+func (a *Archive) sort() error {
+	slices.SortFunc(a.Beacons, func(x, y *grpc.HexBeacon) int {
+		switch {
+		case x.Round < y.Round:
+			return -1
+		case x.Round > y.Round:
+			return 1
+		}
+		return 0
+	})
+	for i := 1; i < len(a.Beacons); i++ {
+		if a.Beacons[i].Round == a.Beacons[i-1].Round {
+			return fmt.Errorf("duplicated round %d in archive", a.Beacons[i].Round)
+		}
+	}
+	return nil
+}
+
+// ReadJSONL reads a JSONL archive, its first line being the V2 chain info and every following line a beacon.
+func ReadJSONL(r io.Reader) (*Archive, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	a := &Archive{Info: new(grpc.JsonInfoV2)}
+	if err := dec.Decode(a.Info); err != nil {
+		return nil, fmt.Errorf("unable to decode chain info: %w", err)
+	}
+
+	for {
+		b := new(grpc.HexBeacon)
+		err := dec.Decode(b)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to decode beacon after %d beacons: %w", len(a.Beacons), err)
+		}
+		a.Beacons = append(a.Beacons, b)
+	}
+
+	return a, a.sort()
+}
+
+// WriteJSONL writes the archive as JSONL, its first line being the V2 chain info and every following line a beacon.
+func WriteJSONL(w io.Writer, a *Archive) error {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(a.Info); err != nil {
+		return err
+	}
+	for _, b := range a.Beacons {
+		if err := enc.Encode(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Supported archive formats.
+const (
+	FormatJSONL  = "jsonl"
+	FormatBinary = "binary"
+)
+
+// Read reads an archive in any of the supported formats, relying on the binary format magic bytes to detect it.
+func Read(r io.Reader) (*Archive, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(binaryMagic))
+	if err == nil && bytes.Equal(magic, binaryMagic) {
+		return ReadBinary(br)
+	}
+	return ReadJSONL(br)
+}
+
+// CheckFormat returns an error if the format is not one of the supported ones.
+func CheckFormat(format string) error {
+	if format != FormatJSONL && format != FormatBinary {
+		return fmt.Errorf("unknown archive format %q, expected %q or %q", format, FormatJSONL, FormatBinary)
+	}
+	return nil
+}
+
+// Write writes the archive in the requested format.
+func Write(w io.Writer, a *Archive, format string) error {
+	if err := CheckFormat(format); err != nil {
+		return err
+	}
+	if format == FormatBinary {
+		return WriteBinary(w, a)
+	}
+	return WriteJSONL(w, a)
+}
+
+// Load reads the archive file at the given path, in any of the supported formats.
+func Load(path string) (*Archive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Read(f)
+}
+
+// Save writes the archive to the file at the given path in the requested format.
+func Save(path string, a *Archive, format string) error {
+	// we don't create the file unless we can write it
+	if err := CheckFormat(format); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := Write(f, a, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Verify checks that the archived chain hash is consistent with the chain info fields and that every beacon is valid
+// for that chain, including the links between consecutive rounds on chained schemes.
+func (a *Archive) Verify() error {
+	if err := a.Info.VerifyHash(); err != nil {
+		return err
+	}
+
+	verify, err := a.Info.Verifier()
+	if err != nil {
+		return err
+	}
+
+	for i, b := range a.Beacons {
+		if err := verify(b); err != nil {
+			return err
+		}
+		if !a.Info.IsChained() || i == 0 || a.Beacons[i-1].Round+1 != b.Round {
+			continue
+		}
+		if !bytes.Equal(a.Beacons[i-1].Signature, b.PreviousSignature) {
+			return fmt.Errorf("round %d previous signature does not match round %d signature", b.Round, b.Round-1)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package archive
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/drand/drand/v2/crypto"
+	"github.com/drand/http-relay/grpc"
+	"github.com/drand/kyber/share"
+	"github.com/drand/kyber/util/random"
+	"github.com/stretchr/testify/require"
+)
+
+// signedArchive returns an archive of the rounds 1 to n of a chain using the provided scheme, signed by a random key.
+func signedArchive(t *testing.T, schemeID string, n uint64) *Archive {
+	t.Helper()
+	sch, err := crypto.SchemeFromName(schemeID)
+	require.NoError(t, err)
+	secret := sch.KeyGroup.Scalar().Pick(random.New())
+	pub, err := sch.KeyGroup.Point().Mul(secret, nil).MarshalBinary()
+	require.NoError(t, err)
+
+	a := &Archive{Info: &grpc.JsonInfoV2{
+		PublicKey:   pub,
+		Period:      3,
+		GenesisTime: 1700000000,
+		GenesisSeed: []byte("seed"),
+		Scheme:      schemeID,
+		BeaconId:    "test",
+	}}
+	a.Info.Hash = a.Info.ComputeHash()
+
+	previous := []byte("seed")
+	for r := uint64(1); r <= n; r++ {
+		b := &grpc.HexBeacon{Round: r}
+		if a.Info.IsChained() {
+			b.PreviousSignature = previous
+		}
+		sig, err := sch.ThresholdScheme.Sign(&share.PriShare{I: 0, V: secret}, sch.DigestBeacon(b))
+		require.NoError(t, err)
+		b.Signature = sig[2:]
+		previous = b.Signature
+		a.Beacons = append(a.Beacons, b)
+	}
+	return a
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	for _, schemeID := range []string{crypto.DefaultSchemeID, crypto.SigsOnG1ID} {
+		for _, format := range []string{FormatJSONL, FormatBinary} {
+			t.Run(schemeID+"-"+format, func(t *testing.T) {
+				a := signedArchive(t, schemeID, 10)
+				require.NoError(t, a.Verify())
+
+				var buf bytes.Buffer
+				require.NoError(t, Write(&buf, a, format))
+				read, err := Read(&buf)
+				require.NoError(t, err)
+				require.Equal(t, a, read)
+				require.NoError(t, read.Verify())
+
+				b, ok := read.Beacon(7)
+				require.True(t, ok)
+				require.Equal(t, uint64(7), b.Round)
+				_, ok = read.Beacon(11)
+				require.False(t, ok)
+			})
+		}
+	}
+}
+
+func TestArchiveBinaryIsCompact(t *testing.T) {
+	a := signedArchive(t, crypto.SigsOnG1ID, 100)
+	var jsonl, bin bytes.Buffer
+	require.NoError(t, WriteJSONL(&jsonl, a))
+	require.NoError(t, WriteBinary(&bin, a))
+	require.Less(t, bin.Len()*2, jsonl.Len())
+}
+
+func TestArchiveVerifyDetectsTampering(t *testing.T) {
+	a := signedArchive(t, crypto.DefaultSchemeID, 5)
+	a.Beacons[2].Signature = a.Beacons[1].Signature
+	require.Error(t, a.Verify())
+
+	a = signedArchive(t, crypto.DefaultSchemeID, 5)
+	a.Info.Period = 30
+	require.Error(t, a.Verify())
+
+	a = signedArchive(t, crypto.DefaultSchemeID, 5)
+	a.Beacons = append(a.Beacons, a.Beacons[0])
+	var buf bytes.Buffer
+	require.NoError(t, WriteBinary(&buf, a))
+	_, err := Read(&buf)
+	require.Error(t, err)
+
+	require.Error(t, Write(&buf, a, "xml"))
+}
@@ -0,0 +1,137 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/drand/http-relay/grpc"
+)
+
+// binaryMagic is starting every binary archive, it is versioned in case we need to change the format later.
+var binaryMagic = []byte("DRANDAR1")
+
+// maxFieldLen is bounding the size of the variable length fields we accept when reading a binary archive.
+const maxFieldLen = 1 << 16
+
+// WriteBinary writes the archive in our compact binary format: the magic bytes, the chain info fields and then every
+// beacon as its round followed by its signature and previous signature. Integers are varint encoded and byte fields
+// are prefixed by their length.
+func WriteBinary(w io.Writer, a *Archive) error {
+	bw := bufio.NewWriter(w)
+	buf := append([]byte{}, binaryMagic...)
+
+	buf = binary.AppendUvarint(buf, uint64(a.Info.Period))
+	buf = binary.AppendVarint(buf, a.Info.GenesisTime)
+	buf = appendBytes(buf, a.Info.PublicKey)
+	buf = appendBytes(buf, a.Info.GenesisSeed)
+	buf = appendBytes(buf, a.Info.Hash)
+	buf = appendBytes(buf, []byte(a.Info.Scheme))
+	buf = appendBytes(buf, []byte(a.Info.BeaconId))
+	buf = binary.AppendUvarint(buf, uint64(len(a.Beacons)))
+	if _, err := bw.Write(buf); err != nil {
+		return err
+	}
+
+	for _, b := range a.Beacons {
+		buf = binary.AppendUvarint(buf[:0], b.Round)
+		buf = appendBytes(buf, b.Signature)
+		buf = appendBytes(buf, b.PreviousSignature)
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+func appendBytes(buf, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// ReadBinary reads an archive written by WriteBinary.
+func ReadBinary(r io.Reader) (*Archive, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(binaryMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, fmt.Errorf("unable to read magic bytes: %w", err)
+	}
+	if !bytes.Equal(magic, binaryMagic) {
+		return nil, errors.New("not a binary archive")
+	}
+
+	rd := &binReader{r: br}
+	info := &grpc.JsonInfoV2{
+		Period:      uint32(rd.uvarint()),
+		GenesisTime: rd.varint(),
+		PublicKey:   rd.bytes(),
+		GenesisSeed: rd.bytes(),
+		Hash:        rd.bytes(),
+		Scheme:      string(rd.bytes()),
+		BeaconId:    string(rd.bytes()),
+	}
+	count := rd.uvarint()
+	if rd.err != nil {
+		return nil, fmt.Errorf("unable to read chain info: %w", rd.err)
+	}
+
+	a := &Archive{Info: info, Beacons: make([]*grpc.HexBeacon, 0, min(count, maxFieldLen))}
+	for i := uint64(0); i < count; i++ {
+		b := &grpc.HexBeacon{
+			Round:             rd.uvarint(),
+			Signature:         rd.bytes(),
+			PreviousSignature: rd.bytes(),
+		}
+		if rd.err != nil {
+			return nil, fmt.Errorf("unable to read beacon %d out of %d: %w", i, count, rd.err)
+		}
+		if len(b.PreviousSignature) == 0 {
+			b.PreviousSignature = nil
+		}
+		a.Beacons = append(a.Beacons, b)
+	}
+
+	return a, a.sort()
+}
+
+// binReader keeps track of the first error encountered, so that we can check it only once per record.
+type binReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (b *binReader) uvarint() uint64 {
+	if b.err != nil {
+		return 0
+	}
+	var v uint64
+	v, b.err = binary.ReadUvarint(b.r)
+	return v
+}
+
+func (b *binReader) varint() int64 {
+	if b.err != nil {
+		return 0
+	}
+	var v int64
+	v, b.err = binary.ReadVarint(b.r)
+	return v
+}
+
+func (b *binReader) bytes() []byte {
+	l := b.uvarint()
+	if b.err != nil {
+		return nil
+	}
+	if l > maxFieldLen {
+		b.err = fmt.Errorf("field too long: %d bytes", l)
+		return nil
+	}
+	buf := make([]byte, l)
+	_, b.err = io.ReadFull(b.r, buf)
+	return buf
+}
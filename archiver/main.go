@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/drand/drand/v2/common"
+	proto "github.com/drand/drand/v2/protobuf/drand"
+	"github.com/drand/http-relay/archive"
+	"github.com/drand/http-relay/grpc"
+)
+
+var version = "v0.0.1"
+
+const usage = `drand beacon archiver %s
+
+Usage:
+  archiver export [flags]   fetches a chain info and a range of rounds from drand nodes into an archive file
+  archiver import [flags]   verifies every beacon of an archive file against its chain info and writes it out
+  archiver verify [flags]   audits beacons from a file or a relay against a chain info, printing a JSON report
+  archiver version          displays the archiver version
+
+Run "archiver <command> -h" for the flags of each command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, usage, version)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = exportCmd(os.Args[2:])
+	case "import":
+		err = importCmd(os.Args[2:])
+	case "verify":
+		err = verifyCmd(os.Args[2:])
+	case "version":
+		fmt.Println("drand beacon archiver version:", version)
+	default:
+		fmt.Fprintf(os.Stderr, usage, version)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func exportCmd(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	grpcURL := fs.String("grpc-connect", "localhost:4444", "The URL and port to your drand node's grpc port, you can add fallback nodes by separating them with a comma.")
+	chainHash := fs.String("chain-hash", "", "The hex-encoded chain hash of the chain to export, takes precedence over --beacon-id.")
+	beaconID := fs.String("beacon-id", common.DefaultBeaconID, "The beacon ID of the chain to export.")
+	from := fs.Uint64("from", 1, "The first round to export.")
+	to := fs.Uint64("to", 0, "The last round to export, 0 means the latest one.")
+	format := fs.String("format", archive.FormatJSONL, "The archive format, either jsonl or binary.")
+	out := fs.String("out", "", "The archive file to write.")
+	timeout := fs.Duration("timeout", 10*time.Second, "The timeout of each call to the drand nodes.")
+	fs.Parse(args)
+
+	if *out == "" {
+		return errors.New("missing --out archive file")
+	}
+	if *from == 0 {
+		return errors.New("invalid --from round 0, rounds start at 1")
+	}
+	// we check the format before fetching the beacons, rather than failing to write them
+	if err := archive.CheckFormat(*format); err != nil {
+		return err
+	}
+
+	m := &proto.Metadata{BeaconID: *beaconID}
+	if *chainHash != "" {
+		hash, err := hex.DecodeString(*chainHash)
+		if err != nil {
+			return fmt.Errorf("unable to decode --chain-hash as hex: %w", err)
+		}
+		m = &proto.Metadata{ChainHash: hash}
+	}
+
+	client, err := grpc.NewClient("fallback:///"+*grpcURL, slog.Default())
+	if err != nil {
+		return fmt.Errorf("failed to create client for %s: %w", *grpcURL, err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	info, err := client.GetChainInfo(ctx, m)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("unable to get chain info: %w", err)
+	}
+
+	last := *to
+	if last == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		latest, err := client.GetBeacon(ctx, m, 0)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("unable to get latest beacon: %w", err)
+		}
+		last = latest.Round
+	}
+	if last < *from {
+		return fmt.Errorf("invalid round range %d-%d", *from, last)
+	}
+
+	a := &archive.Archive{Info: info, Beacons: make([]*grpc.HexBeacon, 0, last-*from+1)}
+	for round := *from; round <= last; round++ {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		b, err := client.GetBeacon(ctx, m, round)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("unable to get round %d: %w", round, err)
+		}
+		// the randomness can be derived from the signature, we don't need to store it
+		b.UnsetRandomness()
+		a.Beacons = append(a.Beacons, b)
+		if round%common.LogsToSkip == 0 {
+			slog.Info("exporting beacons", "round", round, "last", last)
+		}
+	}
+
+	// we never want to produce an archive that would fail to be imported
+	if err := a.Verify(); err != nil {
+		return fmt.Errorf("refusing to export invalid beacons: %w", err)
+	}
+
+	if err := archive.Save(*out, a, *format); err != nil {
+		return fmt.Errorf("unable to write archive: %w", err)
+	}
+
+	slog.Info("exported archive", "chain", info.Hash.String(), "from", *from, "to", last, "file", *out, "format", *format)
+	return nil
+}
+
+func importCmd(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	in := fs.String("in", "", "The archive file to import, in any supported format.")
+	format := fs.String("format", archive.FormatJSONL, "The format of the written archive, either jsonl or binary. The jsonl format can be served using the relay --replay flag.")
+	out := fs.String("out", "", "The file to write the verified archive to.")
+	fs.Parse(args)
+
+	if *in == "" || *out == "" {
+		return errors.New("both --in and --out archive files are required")
+	}
+	if err := archive.CheckFormat(*format); err != nil {
+		return err
+	}
+
+	a, err := archive.Load(*in)
+	if err != nil {
+		return fmt.Errorf("unable to read archive: %w", err)
+	}
+
+	if err := a.Verify(); err != nil {
+		return fmt.Errorf("refusing to import invalid archive: %w", err)
+	}
+
+	if err := archive.Save(*out, a, *format); err != nil {
+		return fmt.Errorf("unable to write archive: %w", err)
+	}
+
+	slog.Info("imported verified archive", "chain", a.Info.Hash.String(), "beacons", len(a.Beacons), "file", *out, "format", *format)
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/drand/drand/v2/crypto"
+	proto "github.com/drand/drand/v2/protobuf/drand"
+	"github.com/drand/http-relay/archive"
+	"github.com/drand/http-relay/grpc"
+	"github.com/drand/kyber/share"
+	"github.com/drand/kyber/util/random"
+	"github.com/stretchr/testify/require"
+	grpclib "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newSigner returns the chain info of a chain using the provided scheme and a function signing its beacons with a
+// random key.
+func newSigner(t *testing.T, schemeID string) (*grpc.JsonInfoV2, func(b *grpc.HexBeacon)) {
+	t.Helper()
+	sch, err := crypto.SchemeFromName(schemeID)
+	require.NoError(t, err)
+	secret := sch.KeyGroup.Scalar().Pick(random.New())
+	pub, err := sch.KeyGroup.Point().Mul(secret, nil).MarshalBinary()
+	require.NoError(t, err)
+
+	info := &grpc.JsonInfoV2{
+		PublicKey:   pub,
+		Period:      3,
+		GenesisTime: 1700000000,
+		GenesisSeed: []byte("seed"),
+		Scheme:      schemeID,
+		BeaconId:    "test",
+	}
+	info.Hash = info.ComputeHash()
+	return info, func(b *grpc.HexBeacon) {
+		sig, err := sch.ThresholdScheme.Sign(&share.PriShare{I: 0, V: secret}, sch.DigestBeacon(b))
+		require.NoError(t, err)
+		b.Signature = sig[2:]
+	}
+}
+
+// signedBeacons returns the rounds 1 to n of the chain, signed using sign.
+func signedBeacons(info *grpc.JsonInfoV2, sign func(b *grpc.HexBeacon), n uint64) []*grpc.HexBeacon {
+	var beacons []*grpc.HexBeacon
+	previous := []byte("seed")
+	for r := uint64(1); r <= n; r++ {
+		b := &grpc.HexBeacon{Round: r}
+		if info.IsChained() {
+			b.PreviousSignature = previous
+		}
+		sign(b)
+		previous = b.Signature
+		beacons = append(beacons, b)
+	}
+	return beacons
+}
+
+// signedArchive returns an archive of the rounds 1 to n of a chain using the provided scheme, signed by a random key.
+func signedArchive(t *testing.T, schemeID string, n uint64) *archive.Archive {
+	t.Helper()
+	info, sign := newSigner(t, schemeID)
+	return &archive.Archive{Info: info, Beacons: signedBeacons(info, sign, n)}
+}
+
+// archiveNode is a minimal drand node serving the beacons of an archive over grpc.
+type archiveNode struct {
+	proto.UnimplementedPublicServer
+	a *archive.Archive
+}
+
+// startNode serves the archive on a random localhost port until the end of the test and returns its address.
+func startNode(t *testing.T, a *archive.Archive) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := grpclib.NewServer()
+	proto.RegisterPublicServer(s, &archiveNode{a: a})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+func (n *archiveNode) metadata() *proto.Metadata {
+	return &proto.Metadata{BeaconID: n.a.Info.BeaconId, ChainHash: n.a.Info.Hash}
+}
+
+func (n *archiveNode) PublicRand(_ context.Context, in *proto.PublicRandRequest) (*proto.PublicRandResponse, error) {
+	round := in.GetRound()
+	if round == 0 {
+		round = n.a.Beacons[len(n.a.Beacons)-1].Round
+	}
+	b, ok := n.a.Beacon(round)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "round %d not found", round)
+	}
+	return &proto.PublicRandResponse{
+		Round:             b.Round,
+		Signature:         b.Signature,
+		PreviousSignature: b.PreviousSignature,
+		Randomness:        crypto.RandomnessFromSignature(b.Signature),
+		Metadata:          n.metadata(),
+	}, nil
+}
+
+func (n *archiveNode) ChainInfo(context.Context, *proto.ChainInfoRequest) (*proto.ChainInfoPacket, error) {
+	info := n.a.Info
+	return &proto.ChainInfoPacket{
+		PublicKey:   info.PublicKey,
+		Period:      info.Period,
+		GenesisTime: info.GenesisTime,
+		Hash:        info.Hash,
+		GroupHash:   info.GenesisSeed,
+		SchemeID:    info.Scheme,
+		Metadata:    n.metadata(),
+	}, nil
+}
+
+func (n *archiveNode) ListBeaconIDs(context.Context, *proto.ListBeaconIDsRequest) (*proto.ListBeaconIDsResponse, error) {
+	return &proto.ListBeaconIDsResponse{Ids: []string{n.a.Info.BeaconId}, Metadatas: []*proto.Metadata{n.metadata()}}, nil
+}
+
+func TestExportImport(t *testing.T) {
+	for _, schemeID := range []string{crypto.DefaultSchemeID, crypto.SigsOnG1ID} {
+		t.Run(schemeID, func(t *testing.T) {
+			a := signedArchive(t, schemeID, 10)
+			addr := startNode(t, a)
+			dir := t.TempDir()
+
+			exported := filepath.Join(dir, "export.bin")
+			require.NoError(t, exportCmd([]string{"--grpc-connect", addr, "--chain-hash", a.Info.Hash.String(),
+				"--from", "3", "--to", "7", "--format", archive.FormatBinary, "--out", exported}))
+			got, err := archive.Load(exported)
+			require.NoError(t, err)
+			require.Equal(t, a.Info, got.Info)
+			require.Equal(t, a.Beacons[2:7], got.Beacons)
+
+			// the latest round is exported by default
+			require.NoError(t, exportCmd([]string{"--grpc-connect", addr, "--beacon-id", "test", "--from", "8", "--out", exported}))
+			got, err = archive.Load(exported)
+			require.NoError(t, err)
+			require.Equal(t, a.Beacons[7:], got.Beacons)
+
+			imported := filepath.Join(dir, "import.jsonl")
+			require.NoError(t, importCmd([]string{"--in", exported, "--out", imported}))
+			data, err := os.ReadFile(imported)
+			require.NoError(t, err)
+			require.Equal(t, byte('{'), data[0])
+			got, err = archive.Load(imported)
+			require.NoError(t, err)
+			require.Equal(t, a.Beacons[7:], got.Beacons)
+		})
+	}
+}
+
+func TestImportRefusesInvalidArchive(t *testing.T) {
+	a := signedArchive(t, crypto.DefaultSchemeID, 5)
+	a.Beacons[3].Signature = a.Beacons[2].Signature
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.jsonl")
+	require.NoError(t, archive.Save(in, a, archive.FormatJSONL))
+
+	out := filepath.Join(dir, "out.jsonl")
+	require.ErrorContains(t, importCmd([]string{"--in", in, "--out", out}), "refusing to import")
+	require.NoFileExists(t, out)
+}
+
+func TestInvalidFormat(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "archive")
+
+	// the format is checked before dialing the nodes, which are not even running
+	err := exportCmd([]string{"--grpc-connect", "127.0.0.1:1", "--format", "xml", "--out", out})
+	require.ErrorContains(t, err, "unknown archive format")
+
+	// and before reading the imported archive
+	err = importCmd([]string{"--in", filepath.Join(t.TempDir(), "missing"), "--format", "xml", "--out", out})
+	require.ErrorContains(t, err, "unknown archive format")
+	require.NoFileExists(t, out)
+}
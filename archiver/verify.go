@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drand/http-relay/archive"
+	"github.com/drand/http-relay/grpc"
+)
+
+// report is the machine-readable result of a verification.
+type report struct {
+	ChainHash    string        `json:"chain_hash"`
+	ComputedHash string        `json:"computed_hash"`
+	HashValid    bool          `json:"hash_valid"`
+	Scheme       string        `json:"scheme"`
+	Chained      bool          `json:"chained"`
+	Checked      int           `json:"checked"`
+	Valid        int           `json:"valid"`
+	Failures     []roundResult `json:"failures"`
+	OK           bool          `json:"ok"`
+}
+
+// roundResult is reporting why a given round failed verification.
+type roundResult struct {
+	Round uint64 `json:"round"`
+	Error string `json:"error"`
+}
+
+func verifyCmd(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	infoPath := fs.String("info", "", "The chain info JSON file to verify against, in its V1 or V2 format. It should come from a trusted source.")
+	beaconsPath := fs.String("beacons", "", "A file of JSON beacons to verify, one per line, or an archive file in any supported format.")
+	relay := fs.String("relay", "", "The base URL of a relay to fetch the beacons from using its V2 API, instead of --beacons.")
+	token := fs.String("token", "", "An optional JWT to authenticate to the relay.")
+	from := fs.Uint64("from", 0, "The first round to fetch from the relay, required with --relay.")
+	to := fs.Uint64("to", 0, "The last round to fetch from the relay, 0 means the latest one.")
+	concurrency := fs.Int("concurrency", 8, "How many rounds are fetched from the relay at once.")
+	out := fs.String("out", "-", "Where to write the JSON report, - for stdout.")
+	fs.Parse(args)
+
+	if *infoPath == "" {
+		return errors.New("missing --info chain info file")
+	}
+	if (*beaconsPath == "") == (*relay == "") {
+		return errors.New("exactly one of --beacons or --relay is required")
+	}
+	// every round is a request to the relay, we don't fetch a whole chain by default
+	if *relay != "" && *from == 0 {
+		return errors.New("--from is required with --relay")
+	}
+	if *concurrency < 1 {
+		return fmt.Errorf("invalid --concurrency %d, it must be positive", *concurrency)
+	}
+
+	data, err := os.ReadFile(*infoPath)
+	if err != nil {
+		return err
+	}
+	info, err := grpc.ParseInfo(data)
+	if err != nil {
+		return err
+	}
+
+	var beacons []*grpc.HexBeacon
+	var failures []roundResult
+	if *beaconsPath != "" {
+		beacons, err = readBeacons(*beaconsPath)
+	} else {
+		beacons, failures, err = fetchBeacons(newRelayClient(*relay, *token, *concurrency), info, *from, *to, *concurrency)
+	}
+	if err != nil {
+		return err
+	}
+
+	rep := verifyBeacons(info, beacons)
+	// fetching failures are verification failures too, we didn't get to check these rounds
+	if len(failures) > 0 {
+		rep.Failures = append(failures, rep.Failures...)
+		rep.OK = false
+	}
+
+	w := os.Stdout
+	if *out != "-" {
+		if w, err = os.Create(*out); err != nil {
+			return err
+		}
+		defer w.Close()
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(rep); err != nil {
+		return err
+	}
+
+	if !rep.OK {
+		return fmt.Errorf("verification failed for %d rounds", len(rep.Failures))
+	}
+	return nil
+}
+
+// verifyBeacons checks the chain hash, every beacon signature and on chained schemes that each beacon is linked to the
+// previous round when we have it.
+func verifyBeacons(info *grpc.JsonInfoV2, beacons []*grpc.HexBeacon) *report {
+	rep := &report{
+		ChainHash:    info.Hash.String(),
+		ComputedHash: fmt.Sprintf("%x", info.ComputeHash()),
+		Scheme:       info.Scheme,
+		Chained:      info.IsChained(),
+		Checked:      len(beacons),
+		Failures:     []roundResult{},
+	}
+	rep.HashValid = info.VerifyHash() == nil
+
+	verify, err := info.Verifier()
+	if err != nil {
+		rep.Failures = append(rep.Failures, roundResult{Error: err.Error()})
+		return rep
+	}
+
+	slices.SortFunc(beacons, func(a, b *grpc.HexBeacon) int {
+		return cmp.Compare(a.Round, b.Round)
+	})
+	for i, b := range beacons {
+		if err := verify(b); err != nil {
+			rep.Failures = append(rep.Failures, roundResult{Round: b.Round, Error: err.Error()})
+			continue
+		}
+		if rep.Chained && i > 0 && beacons[i-1].Round+1 == b.Round && !bytes.Equal(beacons[i-1].Signature, b.PreviousSignature) {
+			rep.Failures = append(rep.Failures, roundResult{Round: b.Round, Error: "previous_signature does not match the previous round signature"})
+			continue
+		}
+		rep.Valid++
+	}
+
+	rep.OK = rep.HashValid && rep.Valid == rep.Checked
+	return rep
+}
+
+// readBeacons reads the beacons of an archive file, in any supported format, or else a sequence of JSON beacons.
+func readBeacons(path string) ([]*grpc.HexBeacon, error) {
+	// a sequence of beacons may decode as a JSONL archive with an empty chain info
+	if a, err := archive.Load(path); err == nil && len(a.Info.PublicKey) > 0 {
+		return a.Beacons, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var beacons []*grpc.HexBeacon
+	dec := json.NewDecoder(f)
+	for {
+		b := new(grpc.HexBeacon)
+		if err := dec.Decode(b); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid beacon after %d beacons: %w", len(beacons), err)
+		}
+		beacons = append(beacons, b)
+	}
+	return beacons, nil
+}
+
+// relayClient is fetching beacons from the V2 API of a relay, sharing its connections between the requests.
+type relayClient struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+// newRelayClient returns a relayClient keeping enough idle connections to the relay for concurrent requests.
+func newRelayClient(base, token string, concurrency int) *relayClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = concurrency
+	return &relayClient{
+		base:   strings.TrimSuffix(base, "/"),
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second, Transport: transport},
+	}
+}
+
+func (r *relayClient) get(path string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, r.base+path, nil)
+	if err != nil {
+		return err
+	}
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %q for %s", resp.Status, path)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// fetchBeacons gets the requested rounds from the relay V2 API, up to concurrency at once, reporting the rounds it
+// failed to fetch.
+func fetchBeacons(r *relayClient, info *grpc.JsonInfoV2, from, to uint64, concurrency int) ([]*grpc.HexBeacon, []roundResult, error) {
+	prefix := "/v2/chains/" + info.Hash.String() + "/rounds/"
+	if to == 0 {
+		latest := new(grpc.HexBeacon)
+		if err := r.get(prefix+"latest", latest); err != nil {
+			return nil, nil, fmt.Errorf("unable to get latest round from relay: %w", err)
+		}
+		to = latest.Round
+	}
+	if to < from {
+		return nil, nil, fmt.Errorf("invalid round range %d-%d", from, to)
+	}
+
+	fetched := make([]*grpc.HexBeacon, to-from+1)
+	errs := make([]error, len(fetched))
+	rounds := make(chan uint64)
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for round := range rounds {
+				b := new(grpc.HexBeacon)
+				err := r.get(fmt.Sprintf("%s%d", prefix, round), b)
+				if err == nil && b.Round != round {
+					err = fmt.Errorf("relay served round %d instead", b.Round)
+				}
+				fetched[round-from], errs[round-from] = b, err
+			}
+		}()
+	}
+	for round := from; round <= to; round++ {
+		rounds <- round
+	}
+	close(rounds)
+	wg.Wait()
+
+	var beacons []*grpc.HexBeacon
+	var failures []roundResult
+	for i, b := range fetched {
+		if errs[i] != nil {
+			failures = append(failures, roundResult{Round: from + uint64(i), Error: errs[i].Error()})
+			continue
+		}
+		beacons = append(beacons, b)
+	}
+	return beacons, failures, nil
+}
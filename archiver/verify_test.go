@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/drand/drand/v2/crypto"
+	"github.com/drand/http-relay/archive"
+	"github.com/drand/http-relay/grpc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyBeacons(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		schemeID string
+		tamper   func(info *grpc.JsonInfoV2, beacons []*grpc.HexBeacon, sign func(*grpc.HexBeacon))
+		hash     bool
+		failures []uint64
+	}{
+		{name: "chained", schemeID: crypto.DefaultSchemeID, hash: true},
+		{name: "unchained", schemeID: crypto.SigsOnG1ID, hash: true},
+		{
+			name:     "bad signature",
+			schemeID: crypto.SigsOnG1ID,
+			tamper: func(_ *grpc.JsonInfoV2, beacons []*grpc.HexBeacon, _ func(*grpc.HexBeacon)) {
+				beacons[2].Signature = beacons[1].Signature
+			},
+			hash:     true,
+			failures: []uint64{3},
+		},
+		{
+			name:     "broken previous signature link",
+			schemeID: crypto.DefaultSchemeID,
+			tamper: func(_ *grpc.JsonInfoV2, beacons []*grpc.HexBeacon, sign func(*grpc.HexBeacon)) {
+				// the round is properly signed, but on top of another previous signature, so is the next one
+				beacons[3].PreviousSignature = beacons[1].Signature
+				sign(beacons[3])
+			},
+			hash:     true,
+			failures: []uint64{4, 5},
+		},
+		{
+			name:     "chain hash mismatch",
+			schemeID: crypto.DefaultSchemeID,
+			tamper: func(info *grpc.JsonInfoV2, _ []*grpc.HexBeacon, _ func(*grpc.HexBeacon)) {
+				info.Hash = make([]byte, len(info.Hash))
+			},
+		},
+		{
+			name:     "unknown scheme",
+			schemeID: crypto.DefaultSchemeID,
+			tamper: func(info *grpc.JsonInfoV2, _ []*grpc.HexBeacon, _ func(*grpc.HexBeacon)) {
+				info.Scheme = "unknown"
+			},
+			// the scheme is not part of the chain hash, but no beacon can be verified
+			hash:     true,
+			failures: []uint64{0},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			info, sign := newSigner(t, tc.schemeID)
+			beacons := signedBeacons(info, sign, 6)
+			if tc.tamper != nil {
+				tc.tamper(info, beacons, sign)
+			}
+
+			rep := verifyBeacons(info, beacons)
+			require.Equal(t, tc.hash, rep.HashValid)
+			require.Equal(t, 6, rep.Checked)
+			var failures []uint64
+			for _, f := range rep.Failures {
+				failures = append(failures, f.Round)
+			}
+			require.Equal(t, tc.failures, failures)
+			require.Equal(t, tc.hash && len(tc.failures) == 0, rep.OK)
+		})
+	}
+}
+
+func TestReadBeacons(t *testing.T) {
+	a := signedArchive(t, crypto.DefaultSchemeID, 3)
+	dir := t.TempDir()
+
+	for _, format := range []string{archive.FormatJSONL, archive.FormatBinary} {
+		path := filepath.Join(dir, "archive."+format)
+		require.NoError(t, archive.Save(path, a, format))
+		beacons, err := readBeacons(path)
+		require.NoError(t, err)
+		require.Equal(t, a.Beacons, beacons)
+	}
+
+	// a sequence of beacons, without any chain info
+	var raw strings.Builder
+	for _, b := range a.Beacons {
+		require.NoError(t, json.NewEncoder(&raw).Encode(b))
+	}
+	path := filepath.Join(dir, "beacons.json")
+	require.NoError(t, os.WriteFile(path, []byte(raw.String()), 0o600))
+	beacons, err := readBeacons(path)
+	require.NoError(t, err)
+	require.Equal(t, a.Beacons, beacons)
+
+	require.NoError(t, os.WriteFile(path, []byte(raw.String()+"not a beacon"), 0o600))
+	_, err = readBeacons(path)
+	require.ErrorContains(t, err, "invalid beacon after 3 beacons")
+
+	_, err = readBeacons(filepath.Join(dir, "missing"))
+	require.Error(t, err)
+}
+
+func TestFetchBeacons(t *testing.T) {
+	a := signedArchive(t, crypto.DefaultSchemeID, 6)
+	prefix := "/v2/chains/" + a.Info.Hash.String() + "/rounds/"
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		round := strings.TrimPrefix(r.URL.Path, prefix)
+		var b *grpc.HexBeacon
+		switch round {
+		case "latest":
+			b = a.Beacons[5]
+		case "4":
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		case "5":
+			// a misbehaving relay serving another round
+			b = a.Beacons[1]
+		default:
+			n, err := strconv.ParseUint(round, 10, 64)
+			require.NoError(t, err)
+			b, _ = a.Beacon(n)
+		}
+		json.NewEncoder(w).Encode(b)
+	}))
+	defer relay.Close()
+
+	client := newRelayClient(relay.URL+"/", "token", 3)
+	beacons, failures, err := fetchBeacons(client, a.Info, 1, 0, 3)
+	require.NoError(t, err)
+	require.Equal(t, []*grpc.HexBeacon{a.Beacons[0], a.Beacons[1], a.Beacons[2], a.Beacons[5]}, beacons)
+	require.Len(t, failures, 2)
+	require.Equal(t, uint64(4), failures[0].Round)
+	require.Contains(t, failures[0].Error, "503")
+	require.Equal(t, uint64(5), failures[1].Round)
+	require.Equal(t, "relay served round 2 instead", failures[1].Error)
+
+	beacons, failures, err = fetchBeacons(client, a.Info, 2, 3, 1)
+	require.NoError(t, err)
+	require.Equal(t, a.Beacons[1:3], beacons)
+	require.Empty(t, failures)
+
+	_, _, err = fetchBeacons(client, a.Info, 7, 0, 3)
+	require.ErrorContains(t, err, "invalid round range")
+
+	_, _, err = fetchBeacons(newRelayClient(relay.URL, "", 3), a.Info, 1, 0, 3)
+	require.ErrorContains(t, err, "unable to get latest round")
+}
+
+func TestVerifyCmd_RelayRequiresFrom(t *testing.T) {
+	info := filepath.Join(t.TempDir(), "info.json")
+	require.ErrorContains(t, verifyCmd([]string{"--info", info, "--relay", "http://localhost:8080"}), "--from is required")
+}
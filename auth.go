@@ -1,57 +1,247 @@
 package main
 
 import (
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
-	"log/slog"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/drand/http-relay/apikeys"
+	"github.com/drand/http-relay/jwks"
+	"github.com/drand/http-relay/presign"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-// AddAuth relies on the DRAND_AUTH_KEY env variable to setup JWT authentication on the v2 API endpoints.
-func AddAuth(next http.Handler) http.Handler {
-	token, provided := os.LookupEnv("DRAND_AUTH_KEY")
-	if !provided || len(token) < 256 {
-		log.Fatal("DRAND_AUTH_KEY not set to a 128 byte hex-encoded secret, disabling authenticated API")
+// authenticator verifies the JWTs signed either using the HMAC secret from the DRAND_AUTH_KEY env variable, or using
+// one of the asymmetric keys of the --auth-jwks key set, and enforces our claims policy.
+type authenticator struct {
+	secret  []byte
+	keys    *jwks.Store
+	methods []string
+	policy  []jwt.ParserOption
+	revoked *revocationList
+}
+
+// revocationCheckInterval is how often we check whether the --auth-revoked file changed.
+const revocationCheckInterval = 10 * time.Second
+
+// newAuthenticator sets up the JWT verification keys, at least one of DRAND_AUTH_KEY or --auth-jwks must be provided.
+// The key set and revocation list are watched until ctx is done.
+func newAuthenticator(ctx context.Context) *authenticator {
+	a := &authenticator{}
+
+	if token, provided := os.LookupEnv("DRAND_AUTH_KEY"); provided {
+		if len(token) < 256 {
+			log.Fatal("DRAND_AUTH_KEY not set to a 128 byte hex-encoded secret, disabling authenticated API")
+		}
+		secret, err := hex.DecodeString(token)
+		if err != nil {
+			log.Fatal("unable to parse DRAND_AUTH_KEY as valid hex, disabling authenticated API")
+		}
+		a.secret = secret
+		a.methods = append(a.methods, "HS256", "HS384")
 	}
 
-	jwtSecret, err := hex.DecodeString(token)
-	if err != nil {
-		log.Fatal("unable to parse DRAND_AUTH_KEY as valid hex, disabling authenticated API")
+	if *jwksSource != "" {
+		keys, err := jwks.NewStore(*jwksSource)
+		if err != nil {
+			log.Fatal("unable to load --auth-jwks key set, disabling authenticated API: ", err)
+		}
+		if *jwksRefresh > 0 {
+			go keys.Watch(ctx, *jwksRefresh)
+		}
+		a.keys = keys
+		a.methods = append(a.methods, jwks.RS256, jwks.ES256, jwks.EdDSA)
 	}
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := strings.Split(r.Header.Get("Authorization"), "Bearer ")
-		if len(authHeader) != 2 {
-			slog.Error("Received invalid request, JWT not recognized", "Authorization", authHeader)
+	if len(a.methods) == 0 {
+		log.Fatal("neither DRAND_AUTH_KEY nor --auth-jwks are set, disabling authenticated API")
+	}
 
-			http.Error(w, "Missing JWT", http.StatusUnauthorized)
-			return
-		}
+	// exp and nbf are always validated when present, we also refuse tokens issued in the future
+	a.policy = []jwt.ParserOption{jwt.WithValidMethods(a.methods), jwt.WithLeeway(*authLeeway), jwt.WithIssuedAt()}
+	if *authExpReq {
+		a.policy = append(a.policy, jwt.WithExpirationRequired())
+	}
+	if *authIssuer != "" {
+		a.policy = append(a.policy, jwt.WithIssuer(*authIssuer))
+	}
+	if *authAud != "" {
+		a.policy = append(a.policy, jwt.WithAudience(*authAud))
+	}
 
-		token, err := jwt.Parse(authHeader[1], func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return jwtSecret, nil
-		}, jwt.WithValidMethods([]string{"HS256", "HS384"}))
+	if *authRevoked != "" {
+		revoked, err := loadRevocationList(*authRevoked)
 		if err != nil {
-			slog.Error("Unable to parse JWT!", "err", err)
-			http.Error(w, "Invalid JWT", http.StatusUnauthorized)
-			return
+			log.Fatal("unable to load --auth-revoked list, disabling authenticated API: ", err)
+		}
+		go revoked.watch(ctx, revocationCheckInterval)
+		a.revoked = revoked
+	}
+
+	return a
+}
+
+// keyFunc returns the key used to verify the token, depending on its signing method and key ID.
+func (a *authenticator) keyFunc(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		return a.secret, nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA, *jwt.SigningMethodEd25519:
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, fmt.Errorf("missing kid header for %v token", token.Header["alg"])
 		}
+		return a.keys.Key(kid, token.Method.Alg())
+	default:
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+}
+
+// apiKeyHeader is the header carrying the API keys of the clients that cannot use JWTs.
+const apiKeyHeader = "X-API-Key"
+
+// loadAPIKeys loads the --auth-api-keys file and keeps watching it for changes until ctx is done.
+func loadAPIKeys(ctx context.Context) *apikeys.Store {
+	keys, err := apikeys.NewStore(*apiKeysFile)
+	if err != nil {
+		log.Fatal("unable to load --auth-api-keys, disabling authenticated API: ", err)
+	}
+	go keys.Watch(ctx, revocationCheckInterval)
+	return keys
+}
 
-		if !token.Valid {
-			slog.Error("Received an invalid JWT!", "from", r.RemoteAddr, "uri", r.RequestURI)
+// apiKeyClaims returns the claims equivalent to the API key, so that API keys are subject to the same scopes, rate
+// limits and usage accounting as JWTs.
+func apiKeyClaims(k *apikeys.Key) *scopeClaims {
+	claims := &scopeClaims{
+		RegisteredClaims: jwt.RegisteredClaims{ID: k.ID, Subject: k.Owner},
+		Chains:           k.Chains,
+		BeaconIDs:        k.BeaconIDs,
+		Ops:              k.Ops,
+		Tier:             k.Tier,
+	}
+	if k.ExpiresAt != nil {
+		claims.ExpiresAt = jwt.NewNumericDate(*k.ExpiresAt)
+	}
+	return claims
+}
+
+// AddAuth relies on the DRAND_AUTH_KEY env variable and on the --auth-jwks key set to setup JWT authentication on the
+// v2 API endpoints, and on the --auth-api-keys file to accept API keys in the X-API-Key header. JWTs are accepted
+// unless only API keys are enabled, using --auth-api-keys without --enable-auth. With --auth-presigned, URLs presigned
+// using the DRAND_AUTH_KEY secret are accepted as well. The files it relies on are watched until ctx is done.
+func AddAuth(ctx context.Context, next http.Handler) http.Handler {
+	return newAuth(ctx)(next)
+}
 
-			http.Error(w, "Invalid JWT", http.StatusUnauthorized)
-			return
+// newAuth returns the middleware of AddAuth, allowing to share its keys between all the route groups using it.
+func newAuth(ctx context.Context) func(http.Handler) http.Handler {
+	var a *authenticator
+	if *requireAuth || *apiKeysFile == "" {
+		a = newAuthenticator(ctx)
+	}
+	var keys *apikeys.Store
+	if *apiKeysFile != "" {
+		keys = loadAPIKeys(ctx)
+	}
+	if *authPresigned && (a == nil || a.secret == nil) {
+		log.Fatal("--auth-presigned requires --enable-auth and the DRAND_AUTH_KEY secret, disabling authenticated API")
+	}
+
+	return func(next http.Handler) http.Handler {
+		if usage != nil {
+			// we account for the usage of the authenticated requests only
+			next = usage.middleware(next)
 		}
 
-		next.ServeHTTP(w, r)
-	})
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if locked, retry := auditor.lockedOut(r); locked {
+				AuthFailures.With(prometheus.Labels{"reason": failLockedOut}).Inc()
+				w.Header().Set("Retry-After", seconds(retry))
+				http.Error(w, "Too many authentication failures", http.StatusTooManyRequests)
+				return
+			}
+
+			if apiKey := r.Header.Get(apiKeyHeader); apiKey != "" && keys != nil {
+				k, err := keys.Verify(apiKey, time.Now())
+				if errors.Is(err, apikeys.ErrExpiredKey) {
+					auditor.fail(r, failAPIKeyExpired, "", err)
+					http.Error(w, "Expired API key", http.StatusUnauthorized)
+					return
+				} else if err != nil {
+					auditor.fail(r, failAPIKey, "", err)
+					http.Error(w, "Invalid API key", http.StatusUnauthorized)
+					return
+				}
+
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsCtxKey{}, apiKeyClaims(k))))
+				return
+			}
+
+			if *authPresigned && presign.Signed(r.URL) {
+				subject, expires, err := presign.Verify(a.secret, r.URL, time.Now(), *authPresignedMax)
+				if err != nil {
+					auditor.fail(r, failPresignedURL, r.URL.Query().Get(presign.SubjectParam), err)
+					http.Error(w, "Invalid URL signature", http.StatusUnauthorized)
+					return
+				}
+
+				// the signature already restricts the accessible paths, so the claims don't restrict anything further
+				claims := &scopeClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: subject, ExpiresAt: jwt.NewNumericDate(expires)}}
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsCtxKey{}, claims)))
+				return
+			}
+
+			if a == nil {
+				auditor.fail(r, failMissing, "", nil)
+				http.Error(w, "Missing API key", http.StatusUnauthorized)
+				return
+			}
+
+			// we never log the Authorization header, since it holds credentials
+			header := r.Header.Get("Authorization")
+			authHeader := strings.Split(header, "Bearer ")
+			if len(authHeader) != 2 {
+				reason := failMalformed
+				if header == "" {
+					reason = failMissing
+				}
+				auditor.fail(r, reason, "", nil)
+				http.Error(w, "Missing JWT", http.StatusUnauthorized)
+				return
+			}
+
+			claims := &scopeClaims{}
+			token, err := jwt.ParseWithClaims(authHeader[1], claims, a.keyFunc, a.policy...)
+			if err != nil {
+				// the claims are filled even when the token is refused, its subject is thus not verified
+				auditor.fail(r, jwtFailure(err), claims.Subject, err)
+				http.Error(w, "Invalid JWT", http.StatusUnauthorized)
+				return
+			}
+
+			if !token.Valid {
+				auditor.fail(r, failSignature, claims.Subject, nil)
+				http.Error(w, "Invalid JWT", http.StatusUnauthorized)
+				return
+			}
+
+			if a.revoked != nil && claims.ID != "" && a.revoked.revoked(claims.ID) {
+				auditor.fail(r, failRevoked, claims.Subject, nil)
+
+				http.Error(w, "Revoked JWT", http.StatusUnauthorized)
+				return
+			}
+
+			// the scopes of the token are enforced on each route by requireScope
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsCtxKey{}, claims)))
+		})
+	}
 }
@@ -1,12 +1,31 @@
 package main
 
 import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
 	"encoding/hex"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/drand/drand/v2/common"
+	drandcrypto "github.com/drand/drand/v2/crypto"
+	proto "github.com/drand/drand/v2/protobuf/drand"
+	"github.com/drand/http-relay/apikeys"
+	"github.com/drand/http-relay/jwks"
+	"github.com/drand/http-relay/local"
+	"github.com/drand/http-relay/presign"
+	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/require"
 )
@@ -22,7 +41,7 @@ func TestAddAuth_AllowsHS256AndHS384(t *testing.T) {
 	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
-	protected := AddAuth(next)
+	protected := AddAuth(context.Background(), next)
 
 	for _, tc := range []struct {
 		name   string
@@ -57,7 +76,7 @@ func TestAddAuth_RejectsHS512(t *testing.T) {
 	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
-	protected := AddAuth(next)
+	protected := AddAuth(context.Background(), next)
 
 	token := jwt.New(jwt.SigningMethodHS512)
 	signed, err := token.SignedString(secret)
@@ -71,3 +90,363 @@ func TestAddAuth_RejectsHS512(t *testing.T) {
 	require.Equal(t, http.StatusUnauthorized, w.Code)
 }
 
+// setJWKS serves the public keys of the provided signers as a JWKS from a local httptest server and configures it as
+// the --auth-jwks key set for the duration of the test.
+func setJWKS(t *testing.T, signers map[string]crypto.Signer) {
+	t.Helper()
+	var set jwks.Set
+	for kid, s := range signers {
+		k, err := jwks.NewKey(kid, s.Public())
+		require.NoError(t, err)
+		set.Keys = append(set.Keys, k)
+	}
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+
+	setFlag(t, jwksSource, srv.URL)
+	setFlag(t, jwksRefresh, 0)
+}
+
+// setFlag sets the value of a flag for the duration of the test.
+func setFlag[T any](t *testing.T, f *T, v T) {
+	t.Helper()
+	prev := *f
+	*f = v
+	t.Cleanup(func() { *f = prev })
+}
+
+func TestAddAuth_AllowsJWKSKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	t.Setenv("DRAND_AUTH_KEY", strings.Repeat("c", 256))
+	setJWKS(t, map[string]crypto.Signer{"rsa": rsaKey, "ec": ecKey, "ed": edKey})
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	protected := AddAuth(context.Background(), next)
+
+	for _, tc := range []struct {
+		name   string
+		method jwt.SigningMethod
+		kid    string
+		key    crypto.Signer
+		code   int
+	}{
+		{name: "rs256", method: jwt.SigningMethodRS256, kid: "rsa", key: rsaKey, code: http.StatusOK},
+		{name: "es256", method: jwt.SigningMethodES256, kid: "ec", key: ecKey, code: http.StatusOK},
+		{name: "eddsa", method: jwt.SigningMethodEdDSA, kid: "ed", key: edKey, code: http.StatusOK},
+		{name: "unknown kid", method: jwt.SigningMethodEdDSA, kid: "unknown", key: edKey, code: http.StatusUnauthorized},
+		{name: "missing kid", method: jwt.SigningMethodEdDSA, key: edKey, code: http.StatusUnauthorized},
+		{name: "wrong key for kid", method: jwt.SigningMethodES256, kid: "ec", key: mustECKey(t), code: http.StatusUnauthorized},
+		{name: "wrong alg for kid", method: jwt.SigningMethodRS384, kid: "rsa", key: rsaKey, code: http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			token := jwt.New(tc.method)
+			if tc.kid != "" {
+				token.Header["kid"] = tc.kid
+			}
+			signed, err := token.SignedString(tc.key)
+			require.NoError(t, err)
+
+			r := httptest.NewRequest(http.MethodGet, "/v2/chains", nil)
+			r.Header.Set("Authorization", "Bearer "+signed)
+			w := httptest.NewRecorder()
+
+			protected.ServeHTTP(w, r)
+			require.Equal(t, tc.code, w.Code)
+		})
+	}
+}
+
+func TestAddAuth_JWKSOnlyRejectsHMAC(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	setJWKS(t, map[string]crypto.Signer{"ed": edKey})
+
+	// the relay doesn't know any HMAC secret, an empty one must not be accepted
+	t.Setenv("DRAND_AUTH_KEY", "")
+	os.Unsetenv("DRAND_AUTH_KEY")
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	protected := AddAuth(context.Background(), next)
+
+	signed, err := jwt.New(jwt.SigningMethodHS256).SignedString([]byte{})
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/v2/chains", nil)
+	r.Header.Set("Authorization", "Bearer "+signed)
+	w := httptest.NewRecorder()
+
+	protected.ServeHTTP(w, r)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func mustECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return k
+}
+
+func TestRequireScope(t *testing.T) {
+	secretHex := strings.Repeat("d", 256)
+	t.Setenv("DRAND_AUTH_KEY", secretHex)
+	secret, err := hex.DecodeString(secretHex)
+	require.NoError(t, err)
+
+	setFlag(t, requireAuth, true)
+
+	dev, err := local.NewDevChain(drandcrypto.SigsOnG1ID, time.Second, common.DefaultBeaconID)
+	require.NoError(t, err)
+	defer dev.Close()
+	info, err := dev.GetChainInfo(context.Background(), &proto.Metadata{BeaconID: common.DefaultBeaconID})
+	require.NoError(t, err)
+	hash := info.Hash.String()
+
+	r := chi.NewRouter()
+	SetupRoutes(context.Background(), r, dev)
+
+	for _, tc := range []struct {
+		name   string
+		claims *scopeClaims
+		path   string
+		code   int
+	}{
+		{name: "no scopes", claims: &scopeClaims{}, path: "/v2/beacons/default/rounds/latest", code: http.StatusOK},
+		{name: "allowed op", claims: &scopeClaims{Ops: []string{opLatest}}, path: "/v2/chains/" + hash + "/rounds/latest", code: http.StatusOK},
+		{name: "forbidden op", claims: &scopeClaims{Ops: []string{opLatest}}, path: "/v2/chains/" + hash + "/rounds/1", code: http.StatusForbidden},
+		{name: "no ops", claims: &scopeClaims{Ops: []string{}}, path: "/v2/beacons/default/rounds/1", code: http.StatusForbidden},
+		{name: "info needs no op", claims: &scopeClaims{Ops: []string{}}, path: "/v2/beacons/default/info", code: http.StatusOK},
+		{name: "allowed chain by hash", claims: &scopeClaims{Chains: []string{strings.ToUpper(hash)}}, path: "/v2/beacons/default/rounds/1", code: http.StatusOK},
+		{name: "allowed chain by beacon ID", claims: &scopeClaims{BeaconIDs: []string{"default"}}, path: "/v2/chains/" + hash + "/info", code: http.StatusOK},
+		{name: "forbidden chain", claims: &scopeClaims{BeaconIDs: []string{"quicknet"}}, path: "/v2/beacons/default/info", code: http.StatusForbidden},
+		// failing to get the chain info is not an authorization failure
+		{name: "unknown chain", claims: &scopeClaims{BeaconIDs: []string{"quicknet"}}, path: "/v2/beacons/quicknet/info", code: http.StatusServiceUnavailable},
+		{name: "chain listing", claims: &scopeClaims{BeaconIDs: []string{"quicknet"}}, path: "/v2/chains", code: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, tc.claims).SignedString(secret)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.Header.Set("Authorization", "Bearer "+signed)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			require.Equal(t, tc.code, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestAddAuth_ClaimsPolicy(t *testing.T) {
+	secretHex := strings.Repeat("e", 256)
+	t.Setenv("DRAND_AUTH_KEY", secretHex)
+	secret, err := hex.DecodeString(secretHex)
+	require.NoError(t, err)
+
+	revokedFile := filepath.Join(t.TempDir(), "revoked.txt")
+	require.NoError(t, os.WriteFile(revokedFile, []byte("# revoked tokens\nleaked\n"), 0o600))
+
+	setFlag(t, authLeeway, 30*time.Second)
+	setFlag(t, authExpReq, true)
+	setFlag(t, authIssuer, "drand-issuer")
+	setFlag(t, authAud, "relay.example.com")
+	setFlag(t, authRevoked, revokedFile)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	protected := AddAuth(context.Background(), next)
+
+	now := time.Now()
+	valid := func() jwt.RegisteredClaims {
+		return jwt.RegisteredClaims{
+			Issuer:    "drand-issuer",
+			Audience:  jwt.ClaimStrings{"relay.example.com"},
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        "token-1",
+		}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		modify func(c *jwt.RegisteredClaims)
+		code   int
+	}{
+		{name: "valid", modify: func(*jwt.RegisteredClaims) {}, code: http.StatusOK},
+		{name: "expired within leeway", modify: func(c *jwt.RegisteredClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(-10 * time.Second))
+		}, code: http.StatusOK},
+		{name: "expired", modify: func(c *jwt.RegisteredClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute))
+		}, code: http.StatusUnauthorized},
+		{name: "missing exp", modify: func(c *jwt.RegisteredClaims) { c.ExpiresAt = nil }, code: http.StatusUnauthorized},
+		{name: "not yet valid", modify: func(c *jwt.RegisteredClaims) {
+			c.NotBefore = jwt.NewNumericDate(now.Add(time.Minute))
+		}, code: http.StatusUnauthorized},
+		{name: "issued in the future", modify: func(c *jwt.RegisteredClaims) {
+			c.IssuedAt = jwt.NewNumericDate(now.Add(time.Minute))
+		}, code: http.StatusUnauthorized},
+		{name: "wrong issuer", modify: func(c *jwt.RegisteredClaims) { c.Issuer = "someone-else" }, code: http.StatusUnauthorized},
+		{name: "wrong audience", modify: func(c *jwt.RegisteredClaims) {
+			c.Audience = jwt.ClaimStrings{"other.example.com"}
+		}, code: http.StatusUnauthorized},
+		{name: "one of the audiences", modify: func(c *jwt.RegisteredClaims) {
+			c.Audience = jwt.ClaimStrings{"other.example.com", "relay.example.com"}
+		}, code: http.StatusOK},
+		{name: "revoked", modify: func(c *jwt.RegisteredClaims) { c.ID = "leaked" }, code: http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			claims := valid()
+			tc.modify(&claims)
+			signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+			require.NoError(t, err)
+
+			r := httptest.NewRequest(http.MethodGet, "/v2/chains", nil)
+			r.Header.Set("Authorization", "Bearer "+signed)
+			w := httptest.NewRecorder()
+
+			protected.ServeHTTP(w, r)
+			require.Equal(t, tc.code, w.Code)
+		})
+	}
+}
+
+func TestRevocationListReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked.txt")
+	require.NoError(t, os.WriteFile(path, []byte("a\n\n# comment\n b \n"), 0o600))
+
+	l, err := loadRevocationList(path)
+	require.NoError(t, err)
+	require.True(t, l.revoked("a"))
+	require.True(t, l.revoked("b"))
+	require.False(t, l.revoked("# comment"))
+	require.False(t, l.revoked("c"))
+
+	reloaded, err := l.reload()
+	require.NoError(t, err)
+	require.False(t, reloaded)
+
+	require.NoError(t, os.WriteFile(path, []byte("a\nc\n"), 0o600))
+	reloaded, err = l.reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	require.False(t, l.revoked("b"))
+	require.True(t, l.revoked("c"))
+
+	// a missing file keeps the current list
+	require.NoError(t, os.Remove(path))
+	_, err = l.reload()
+	require.Error(t, err)
+	require.True(t, l.revoked("c"))
+}
+
+func TestAddAuth_APIKeys(t *testing.T) {
+	apiKey, k, err := apikeys.New("acme")
+	require.NoError(t, err)
+	k.Ops = []string{opLatest}
+	expired, e, err := apikeys.New("old")
+	require.NoError(t, err)
+	past := time.Now().Add(-time.Minute)
+	e.ExpiresAt = &past
+	data, err := json.Marshal([]apikeys.Key{k, e})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	setFlag(t, apiKeysFile, path)
+
+	secret := strings.Repeat("c", 256)
+	t.Setenv("DRAND_AUTH_KEY", secret)
+	key, err := hex.DecodeString(secret)
+	require.NoError(t, err)
+	signed, err := jwt.New(jwt.SigningMethodHS256).SignedString(key)
+	require.NoError(t, err)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFrom(r.Context())
+		require.True(t, ok)
+		w.Write([]byte(claims.Subject + " " + strings.Join(claims.Ops, ",")))
+	})
+	call := func(h http.Handler, header, value string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/v2/chains", nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// only API keys are accepted without --enable-auth
+	setFlag(t, requireAuth, false)
+	protected := AddAuth(context.Background(), next)
+	w := call(protected, apiKeyHeader, apiKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "acme latest", w.Body.String())
+	require.Equal(t, http.StatusUnauthorized, call(protected, apiKeyHeader, expired).Code)
+	require.Equal(t, http.StatusUnauthorized, call(protected, apiKeyHeader, apiKey+"0").Code)
+	require.Equal(t, http.StatusUnauthorized, call(protected, "Authorization", "Bearer "+signed).Code)
+	require.Equal(t, http.StatusUnauthorized, call(protected, "", "").Code)
+
+	// both are accepted with --enable-auth
+	setFlag(t, requireAuth, true)
+	protected = AddAuth(context.Background(), next)
+	require.Equal(t, http.StatusOK, call(protected, apiKeyHeader, apiKey).Code)
+	require.Equal(t, http.StatusOK, call(protected, "Authorization", "Bearer "+signed).Code)
+	require.Equal(t, http.StatusUnauthorized, call(protected, apiKeyHeader, expired).Code)
+}
+
+func TestAddAuth_PresignedURLs(t *testing.T) {
+	secret := strings.Repeat("c", 256)
+	t.Setenv("DRAND_AUTH_KEY", secret)
+	key, err := hex.DecodeString(secret)
+	require.NoError(t, err)
+	setFlag(t, authPresigned, true)
+	setFlag(t, authPresignedMax, 2*time.Hour)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFrom(r.Context())
+		require.True(t, ok)
+		w.Write([]byte(claims.Subject))
+	})
+	protected := AddAuth(context.Background(), next)
+	call := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		protected.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	signed, err := presign.Sign(key, "/v2/beacons/quicknet/rounds/latest", time.Now().Add(time.Hour), presign.WithSubject("cdn"))
+	require.NoError(t, err)
+	w := call(signed)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "cdn", w.Body.String())
+
+	require.Equal(t, http.StatusUnauthorized, call(strings.Replace(signed, "latest", "next", 1)).Code)
+	require.Equal(t, http.StatusUnauthorized, call("/v2/beacons/quicknet/rounds/latest").Code)
+
+	expired, err := presign.Sign(key, "/v2/beacons/quicknet/rounds/latest", time.Now().Add(-time.Second))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, call(expired).Code)
+
+	tooLong, err := presign.Sign(key, "/v2/beacons/quicknet/rounds/latest", time.Now().Add(3*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, call(tooLong).Code)
+
+	wrongKey, err := presign.Sign([]byte("other"), "/v2/beacons/quicknet/rounds/latest", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, call(wrongKey).Code)
+}
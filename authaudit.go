@@ -0,0 +1,264 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The reasons of the authentication failures, as logged and counted in the http_auth_failures metric.
+const (
+	failMissing       = "missing"
+	failMalformed     = "malformed"
+	failSignature     = "invalid_signature"
+	failExpired       = "expired"
+	failClaims        = "invalid_claims"
+	failRevoked       = "revoked"
+	failAPIKey        = "invalid_api_key"
+	failAPIKeyExpired = "expired_api_key"
+	failPresignedURL  = "invalid_presigned_url"
+	failLockedOut     = "locked_out"
+)
+
+// auditSweepInterval is how often we forget the past failures, to bound our memory usage.
+const auditSweepInterval = time.Minute
+
+// jwtFailure returns the reason why a JWT was refused.
+func jwtFailure(err error) string {
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return failMalformed
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return failSignature
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return failExpired
+	}
+	return failClaims
+}
+
+// auditMaxSubjects bounds how many token subjects we audit at once, since the subjects of refused tokens are chosen by
+// their senders.
+const auditMaxSubjects = 10000
+
+// trustedProxies are the --trusted-proxies, whose X-Forwarded-For headers are used to find the IP of their clients.
+var trustedProxies []netip.Prefix
+
+// isTrustedProxy returns whether the IP is one of the --trusted-proxies.
+func isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	return slices.ContainsFunc(trustedProxies, func(p netip.Prefix) bool { return p.Contains(addr) })
+}
+
+// clientIP returns the IP of the client of the request, without its port. When the request comes from one of the
+// --trusted-proxies, it is the last IP of its X-Forwarded-For header that is not a trusted proxy, since the previous
+// ones are set by the client itself.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			// we cannot trust anything set before an invalid hop
+			break
+		}
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+type failures struct {
+	count       int
+	start       time.Time
+	reasons     map[string]int
+	lockedUntil time.Time
+}
+
+// authAuditor aggregates the authentication failures per client IP and per token subject, and locks out the IPs
+// failing more than --auth-lockout-failures times per --auth-lockout-window. Subjects are never locked out, since
+// anyone can forge a token with the subject of someone else, but up to auditMaxSubjects of them are audited. The
+// --trusted-proxies and the Unix socket peers are never locked out either, since it would lock out all their clients.
+type authAuditor struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	ips       map[string]*failures
+	subjects  map[string]*failures
+	lastSweep time.Time
+}
+
+// auditor is auditing the authentication failures of AddAuth.
+var auditor = newAuthAuditor()
+
+func newAuthAuditor() *authAuditor {
+	return &authAuditor{
+		now:      time.Now,
+		ips:      make(map[string]*failures),
+		subjects: make(map[string]*failures),
+	}
+}
+
+// add counts a failure in the current window of key, it must be called with the lock held.
+func add(m map[string]*failures, key, reason string, now time.Time) *failures {
+	f, ok := m[key]
+	if !ok || now.Sub(f.start) > *lockoutWindow {
+		f = &failures{start: now, reasons: make(map[string]int)}
+		if ok {
+			// a new window doesn't lift an ongoing lockout
+			f.lockedUntil = m[key].lockedUntil
+		}
+		m[key] = f
+	}
+	f.count++
+	f.reasons[reason]++
+	return f
+}
+
+// fail records an authentication failure of the request. The subject is the one claimed by the refused token, if
+// any, which wasn't verified. Credentials are never logged.
+func (a *authAuditor) fail(r *http.Request, reason, subject string, err error) {
+	ip := clientIP(r)
+	now := a.now()
+	AuthFailures.With(prometheus.Labels{"reason": reason}).Inc()
+
+	a.mu.Lock()
+	a.sweep(now)
+	f := add(a.ips, ip, reason, now)
+	if _, ok := a.subjects[subject]; subject != "" && (ok || len(a.subjects) < auditMaxSubjects) {
+		add(a.subjects, subject, reason, now)
+	}
+	count := f.count
+	lockout := *lockoutFailures > 0 && count >= *lockoutFailures && !now.Before(f.lockedUntil) && !isTrustedProxy(ip) && !unixPeer(r)
+	if lockout {
+		f.lockedUntil = now.Add(*lockoutDuration)
+	}
+	a.mu.Unlock()
+
+	slog.Warn("authentication failure", "reason", reason, "ip", ip, "unverified_sub", subject, "uri", r.URL.Path, "err", err)
+	if lockout {
+		AuthLockouts.Inc()
+		slog.Warn("locking out client after too many authentication failures", "ip", ip, "failures", count, "duration", *lockoutDuration)
+	}
+}
+
+// lockedOut returns whether the client IP of the request is locked out, and for how long.
+func (a *authAuditor) lockedOut(r *http.Request) (bool, time.Duration) {
+	if unixPeer(r) {
+		return false, 0
+	}
+	now := a.now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, ok := a.ips[clientIP(r)]
+	if !ok || !now.Before(f.lockedUntil) {
+		return false, 0
+	}
+	return true, f.lockedUntil.Sub(now)
+}
+
+// sweep forgets the failures of the past windows that are not locked out anymore, it must be called with the lock
+// held.
+func (a *authAuditor) sweep(now time.Time) {
+	if now.Sub(a.lastSweep) < auditSweepInterval {
+		return
+	}
+	for _, m := range []map[string]*failures{a.ips, a.subjects} {
+		for k, f := range m {
+			if now.Sub(f.start) > *lockoutWindow && !now.Before(f.lockedUntil) {
+				delete(m, k)
+			}
+		}
+	}
+	a.lastSweep = now
+}
+
+// counts returns how many IPs and subjects failed to authenticate during their current window, and how many IPs are
+// locked out.
+func (a *authAuditor) counts() (ips, subjects, locked int) {
+	now := a.now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, f := range a.ips {
+		if now.Sub(f.start) <= *lockoutWindow {
+			ips++
+		}
+		if now.Before(f.lockedUntil) {
+			locked++
+		}
+	}
+	for _, f := range a.subjects {
+		if now.Sub(f.start) <= *lockoutWindow {
+			subjects++
+		}
+	}
+	return ips, subjects, locked
+}
+
+type failureReport struct {
+	IP          string         `json:"ip,omitempty"`
+	Subject     string         `json:"subject,omitempty"`
+	Failures    int            `json:"failures"`
+	Since       time.Time      `json:"since"`
+	Reasons     map[string]int `json:"reasons"`
+	LockedUntil *time.Time     `json:"locked_until,omitempty"`
+}
+
+// ServeHTTP exports the failures of the current windows per client IP and per subject, the most failing first.
+func (a *authAuditor) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	now := a.now()
+	report := func(m map[string]*failures, ip bool) []failureReport {
+		reports := make([]failureReport, 0, len(m))
+		for k, f := range m {
+			rep := failureReport{Failures: f.count, Since: f.start, Reasons: f.reasons}
+			if ip {
+				rep.IP = k
+			} else {
+				rep.Subject = k
+			}
+			if now.Before(f.lockedUntil) {
+				until := f.lockedUntil
+				rep.LockedUntil = &until
+			}
+			reports = append(reports, rep)
+		}
+		slices.SortFunc(reports, func(a, b failureReport) int {
+			return cmp.Or(cmp.Compare(b.Failures, a.Failures), cmp.Compare(a.IP+a.Subject, b.IP+b.Subject))
+		})
+		return reports
+	}
+
+	a.mu.Lock()
+	a.sweep(now)
+	data, err := json.Marshal(map[string]any{"ips": report(a.ips, true), "subjects": report(a.subjects, false)})
+	a.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWTFailure(t *testing.T) {
+	secret := []byte("secret")
+	parse := func(token string) error {
+		_, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return secret, nil }, jwt.WithIssuer("drand"))
+		return err
+	}
+	sign := func(key []byte, claims jwt.MapClaims) string {
+		s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+		require.NoError(t, err)
+		return s
+	}
+
+	require.Equal(t, failMalformed, jwtFailure(parse("not.a.token")))
+	require.Equal(t, failSignature, jwtFailure(parse(sign([]byte("other"), jwt.MapClaims{"iss": "drand"}))))
+	require.Equal(t, failExpired, jwtFailure(parse(sign(secret, jwt.MapClaims{"iss": "drand", "exp": time.Now().Add(-time.Hour).Unix()}))))
+	require.Equal(t, failClaims, jwtFailure(parse(sign(secret, jwt.MapClaims{"iss": "other"}))))
+}
+
+func TestAddAuth_AuditAndLockout(t *testing.T) {
+	secret := strings.Repeat("c", 256)
+	t.Setenv("DRAND_AUTH_KEY", secret)
+	key, err := hex.DecodeString(secret)
+	require.NoError(t, err)
+
+	a := newAuthAuditor()
+	now := time.Unix(1_700_000_000, 0)
+	a.now = func() time.Time { return now }
+	setFlag(t, &auditor, a)
+	setFlag(t, lockoutFailures, 3)
+	setFlag(t, lockoutWindow, time.Minute)
+	setFlag(t, lockoutDuration, 5*time.Minute)
+
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	protected := AddAuth(context.Background(), http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	call := func(ip, authorization string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/v2/chains", nil)
+		r.RemoteAddr = ip + ":1234"
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		protected.ServeHTTP(w, r)
+		return w
+	}
+
+	valid, err := jwt.New(jwt.SigningMethodHS256).SignedString(key)
+	require.NoError(t, err)
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "victim"}).SignedString([]byte("guess"))
+	require.NoError(t, err)
+
+	before := testutil.ToFloat64(AuthFailures.WithLabelValues(failSignature))
+	require.Equal(t, http.StatusUnauthorized, call("192.0.2.1", "Bearer "+forged).Code)
+	require.Equal(t, http.StatusUnauthorized, call("192.0.2.1", "Basic "+forged).Code)
+	require.Equal(t, http.StatusOK, call("192.0.2.1", "Bearer "+valid).Code)
+	require.Equal(t, http.StatusUnauthorized, call("192.0.2.1", "Bearer "+forged).Code)
+	require.Equal(t, 2.0, testutil.ToFloat64(AuthFailures.WithLabelValues(failSignature))-before)
+
+	// the client is locked out after its third failure, even with a valid token, while others are not
+	w := call("192.0.2.1", "Bearer "+valid)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "300", w.Header().Get("Retry-After"))
+	require.Equal(t, http.StatusOK, call("192.0.2.2", "Bearer "+valid).Code)
+
+	ips, subjects, locked := a.counts()
+	require.Equal(t, 1, ips)
+	require.Equal(t, 1, subjects)
+	require.Equal(t, 1, locked)
+
+	rr := httptest.NewRecorder()
+	a.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/auth-failures", nil))
+	var report struct {
+		IPs      []failureReport `json:"ips"`
+		Subjects []failureReport `json:"subjects"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	require.Len(t, report.IPs, 1)
+	require.Equal(t, "192.0.2.1", report.IPs[0].IP)
+	require.Equal(t, map[string]int{failSignature: 2, failMalformed: 1}, report.IPs[0].Reasons)
+	require.NotNil(t, report.IPs[0].LockedUntil)
+	require.Len(t, report.Subjects, 1)
+	require.Equal(t, "victim", report.Subjects[0].Subject)
+	require.Equal(t, 2, report.Subjects[0].Failures)
+
+	// the lockout expires
+	now = now.Add(5 * time.Minute)
+	require.Equal(t, http.StatusOK, call("192.0.2.1", "Bearer "+valid).Code)
+
+	// credentials never end up in the logs
+	require.Contains(t, logs.String(), "authentication failure")
+	require.NotContains(t, logs.String(), forged)
+	require.NotContains(t, logs.String(), valid)
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseAllowlist("10.0.0.0/8,2001:db8::1")
+	require.NoError(t, err)
+	setFlag(t, &trustedProxies, proxies)
+
+	for _, tc := range []struct {
+		name      string
+		remote    string
+		forwarded []string
+		ip        string
+	}{
+		{name: "direct", remote: "192.0.2.1:1234", ip: "192.0.2.1"},
+		{name: "direct ignores forwarded", remote: "192.0.2.1:1234", forwarded: []string{"198.51.100.1"}, ip: "192.0.2.1"},
+		{name: "proxy", remote: "10.0.0.1:1234", forwarded: []string{"198.51.100.1"}, ip: "198.51.100.1"},
+		{name: "proxy without forwarded", remote: "10.0.0.1:1234", ip: "10.0.0.1"},
+		{name: "ipv6 proxy", remote: "[2001:db8::1]:1234", forwarded: []string{"198.51.100.1"}, ip: "198.51.100.1"},
+		{name: "spoofed hops", remote: "10.0.0.1:1234", forwarded: []string{"203.0.113.9, 198.51.100.1"}, ip: "198.51.100.1"},
+		{name: "chained proxies", remote: "10.0.0.1:1234", forwarded: []string{"203.0.113.9, 198.51.100.1, 10.0.0.2"}, ip: "198.51.100.1"},
+		{name: "multiple headers", remote: "10.0.0.1:1234", forwarded: []string{"203.0.113.9", "198.51.100.1"}, ip: "198.51.100.1"},
+		{name: "invalid hop", remote: "10.0.0.1:1234", forwarded: []string{"198.51.100.1, garbage"}, ip: "10.0.0.1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tc.remote
+			for _, f := range tc.forwarded {
+				r.Header.Add("X-Forwarded-For", f)
+			}
+			require.Equal(t, tc.ip, clientIP(r))
+		})
+	}
+}
+
+func TestAuthAuditor_BehindProxy(t *testing.T) {
+	proxies, err := parseAllowlist("10.0.0.1")
+	require.NoError(t, err)
+	setFlag(t, &trustedProxies, proxies)
+	setFlag(t, lockoutFailures, 1)
+	a := newAuthAuditor()
+
+	request := func(forwarded string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		if forwarded != "" {
+			r.Header.Set("X-Forwarded-For", forwarded)
+		}
+		return r
+	}
+
+	// the clients of the proxy are locked out, not the proxy itself
+	a.fail(request("192.0.2.1"), failSignature, "", nil)
+	locked, _ := a.lockedOut(request("192.0.2.1"))
+	require.True(t, locked)
+	locked, _ = a.lockedOut(request("192.0.2.2"))
+	require.False(t, locked)
+
+	a.fail(request(""), failSignature, "", nil)
+	locked, _ = a.lockedOut(request(""))
+	require.False(t, locked)
+
+	// the subjects are chosen by the clients, we only audit so many of them
+	for i := range auditMaxSubjects + 10 {
+		a.fail(request("192.0.2.3"), failSignature, strconv.Itoa(i), nil)
+	}
+	_, subjects, _ := a.counts()
+	require.Equal(t, auditMaxSubjects, subjects)
+	a.fail(request("192.0.2.3"), failSignature, "0", nil)
+	require.Equal(t, 2, a.subjects["0"].count)
+}
+
+func TestAuthAuditor_UnixPeers(t *testing.T) {
+	setFlag(t, lockoutFailures, 1)
+	a := newAuthAuditor()
+
+	// all the Unix socket peers share the same address, locking out one would lock out all of them
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "@"
+	r = r.WithContext(context.WithValue(r.Context(), unixPeerCtxKey{}, true))
+	a.fail(r, failSignature, "", nil)
+	locked, _ := a.lockedOut(r)
+	require.False(t, locked)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"context"
+
+	proto "github.com/drand/drand/v2/protobuf/drand"
+	"github.com/drand/http-relay/grpc"
+)
+
+// Client is the set of calls our handlers rely on to serve beacons. It is implemented by the grpc.Client talking to
+// actual drand nodes, but also by the local chains used in dev-chain mode.
+type Client interface {
+	GetBeacon(ctx context.Context, m *proto.Metadata, round uint64) (*grpc.HexBeacon, error)
+	Next(ctx context.Context, m *proto.Metadata) (*grpc.HexBeacon, error)
+	GetChainInfo(ctx context.Context, m *proto.Metadata) (*grpc.JsonInfoV2, error)
+	GetChains(ctx context.Context) ([]string, error)
+	GetBeaconIds(ctx context.Context) ([]string, []*proto.Metadata, error)
+	Close() error
+}
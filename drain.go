@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	proto "github.com/drand/drand/v2/protobuf/drand"
+	"github.com/drand/http-relay/grpc"
+)
+
+const (
+	// shutdownGrace is how long the in-flight requests have to finish once we start shutting down.
+	shutdownGrace = 30 * time.Second
+	// drainMargin is how long before the end of shutdownGrace the long-polls still waiting for the next round are
+	// told to retry, so that they are answered rather than cut.
+	drainMargin = 5 * time.Second
+)
+
+// errDraining is returned to the long-polls waiting for the next round while we are shutting down.
+var errDraining = errors.New("relay shutting down")
+
+// shuttingDown is set once we stop accepting new requests, making /readyz fail.
+var shuttingDown atomic.Bool
+
+// drainCtx is cancelled when the long-polls waiting for the next round must be answered, using startDrain.
+var drainCtx, startDrain = context.WithCancel(context.Background())
+
+// waitNext waits for the next beacon like c.Next, unless we start draining before it comes in which case it returns
+// errDraining.
+func waitNext(c Client, r *http.Request, m *proto.Metadata) (*grpc.HexBeacon, error) {
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+	stop := context.AfterFunc(drainCtx, func() { cancel(errDraining) })
+	defer stop()
+
+	beacon, err := c.Next(ctx, m)
+	if err != nil && errors.Is(context.Cause(ctx), errDraining) {
+		return nil, errDraining
+	}
+	return beacon, err
+}
+
+// writeDraining tells the client to retry its request, which should reach another relay or our upgraded one.
+func writeDraining(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Retry-After", "1")
+	w.Header().Set("Connection", "close")
+	http.Error(w, "Relay shutting down, please retry", http.StatusServiceUnavailable)
+}
+
+// handlers counts the requests being handled, since http.Server.Shutdown doesn't wait for them once it times out, nor
+// for the ones of hijacked connections, like h2c ones.
+var handlers atomic.Int64
+
+// trackHandlers counts the requests being handled by next in handlers.
+func trackHandlers(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlers.Add(1)
+		defer handlers.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// waitHandlers waits for the requests being handled to finish, up to timeout, and returns whether they did.
+func waitHandlers(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for handlers.Load() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// shutdown gracefully shuts the server down, letting the in-flight requests finish for up to grace. The long-polls
+// still waiting for the next round margin before the end of grace are told to retry rather than being cut.
+func shutdown(ctx context.Context, server *http.Server, grace, margin time.Duration) {
+	shutdownCtx, cancel := context.WithTimeout(ctx, grace)
+	defer cancel()
+	drainAt := time.Now().Add(grace - margin)
+	drain := time.AfterFunc(grace-margin, startDrain)
+	defer drain.Stop()
+	go func() {
+		<-shutdownCtx.Done()
+		if errors.Is(shutdownCtx.Err(), context.DeadlineExceeded) {
+			slog.Error("graceful shutdown timed out.. forcing exit")
+			return
+		}
+	}()
+
+	// Trigger graceful shutdown
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("server Shutdown error", "err", err)
+		startDrain()
+		server.Close()
+		return
+	}
+	// Shutdown doesn't wait for the requests of hijacked connections, like h2c ones, which are only told to go away
+	if !waitHandlers(time.Until(drainAt)) {
+		startDrain()
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/drand/drand/v2/common"
+	drandcrypto "github.com/drand/drand/v2/crypto"
+	proto "github.com/drand/drand/v2/protobuf/drand"
+	"github.com/drand/http-relay/local"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+func TestDrainLongPolls(t *testing.T) {
+	dev, err := local.NewDevChain(drandcrypto.SigsOnG1ID, time.Minute, common.DefaultBeaconID)
+	require.NoError(t, err)
+	defer dev.Close()
+	info, err := dev.GetChainInfo(context.Background(), &proto.Metadata{BeaconID: common.DefaultBeaconID})
+	require.NoError(t, err)
+	_, next := info.ExpectedNext()
+
+	r := chi.NewRouter()
+	SetupRoutes(context.Background(), r, dev)
+
+	for _, path := range []string{
+		"/v2/beacons/default/rounds/next",
+		"/v2/chains/" + info.Hash.String() + "/rounds/" + strconv.FormatUint(next, 10),
+		"/public/" + strconv.FormatUint(next, 10),
+	} {
+		t.Run(path, func(t *testing.T) {
+			ctx, drain := context.WithCancel(context.Background())
+			setFlag(t, &drainCtx, ctx)
+
+			w := httptest.NewRecorder()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			}()
+
+			// the long-poll waits for the next round until we start draining
+			select {
+			case <-done:
+				t.Fatalf("long-poll answered before draining: %d %s", w.Code, w.Body.String())
+			case <-time.After(100 * time.Millisecond):
+			}
+			drain()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("long-poll not answered once draining")
+			}
+			require.Equal(t, http.StatusServiceUnavailable, w.Code)
+			require.Equal(t, "1", w.Header().Get("Retry-After"))
+			require.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+		})
+	}
+
+	// other requests are served as usual while draining
+	ctx, drain := context.WithCancel(context.Background())
+	drain()
+	setFlag(t, &drainCtx, ctx)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v2/beacons/default/rounds/latest", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestWaitHandlers(t *testing.T) {
+	release := make(chan struct{})
+	h := trackHandlers(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		<-release
+	}))
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	require.Eventually(t, func() bool { return handlers.Load() == 1 }, time.Second, time.Millisecond)
+	require.False(t, waitHandlers(50*time.Millisecond))
+	close(release)
+	require.True(t, waitHandlers(time.Second))
+}
+
+func TestShutdown_DrainsH2CLongPolls(t *testing.T) {
+	dev, err := local.NewDevChain(drandcrypto.SigsOnG1ID, time.Minute, common.DefaultBeaconID)
+	require.NoError(t, err)
+	defer dev.Close()
+	ctx, drain := context.WithCancel(context.Background())
+	setFlag(t, &drainCtx, ctx)
+	setFlag(t, &startDrain, drain)
+
+	r := chi.NewRouter()
+	SetupRoutes(context.Background(), r, dev)
+	server := &http.Server{Handler: trackHandlers(r)}
+	require.NoError(t, withH2C(server))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go serve(server, []net.Listener{l})
+
+	// load balancers multiplex the long-polls over a single connection with prior knowledge
+	h2c := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	codes := make(chan int, 2)
+	for range 2 {
+		go func() {
+			resp, err := h2c.Get("http://" + l.Addr().String() + "/v2/beacons/default/rounds/next")
+			if err != nil {
+				codes <- 0
+				return
+			}
+			resp.Body.Close()
+			codes <- resp.StatusCode
+		}()
+	}
+	require.Eventually(t, func() bool { return handlers.Load() == 2 }, 5*time.Second, time.Millisecond)
+
+	// the h2c long-polls are answered once draining, rather than cut when the server stops
+	start := time.Now()
+	shutdown(context.Background(), server, time.Second, 500*time.Millisecond)
+	require.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+	for range 2 {
+		select {
+		case code := <-codes:
+			require.Equal(t, http.StatusServiceUnavailable, code)
+		case <-time.After(5 * time.Second):
+			t.Fatal("h2c long-poll not answered once draining")
+		}
+	}
+	require.True(t, waitHandlers(time.Second))
+}
@@ -0,0 +1,27 @@
+package grpc
+
+import (
+	"context"
+	"time"
+)
+
+// DiscoverChains retries loading the chain infos until any backend answers, allowing the relay to start while all the
+// backends are unreachable rather than crash looping. The delay between attempts starts at backoff and doubles on
+// every failure, up to maxBackoff. It returns once the chains are known, or the context error if it is done first.
+func (c *Client) DiscoverChains(ctx context.Context, backoff, maxBackoff time.Duration) error {
+	for {
+		chains, err := c.GetChains(ctx)
+		if err == nil {
+			c.log.Info("chains discovered", "chains", chains)
+			return nil
+		}
+		c.log.Warn("unable to discover the chains, retrying", "in", backoff, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
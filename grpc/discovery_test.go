@@ -0,0 +1,82 @@
+package grpc
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	proto "github.com/drand/drand/v2/protobuf/drand"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func TestDiscoverChains(t *testing.T) {
+	// we reserve an address for a node that is down when the relay starts
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := lis.Addr().String()
+	require.NoError(t, lis.Close())
+
+	c, err := NewClient("fallback:///"+addr, slog.Default())
+	require.Error(t, err)
+	defer c.Close()
+	require.Empty(t, c.KnownChains())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, c.DiscoverChains(ctx, 10*time.Millisecond, 20*time.Millisecond), context.DeadlineExceeded)
+
+	// the chains are discovered once the node comes up
+	info := newFakeInfo("quicknet", "discovery")
+	lis, err = net.Listen("tcp", addr)
+	require.NoError(t, err)
+	s := grpc.NewServer()
+	proto.RegisterPublicServer(s, newFakeNode(info, 10))
+	go s.Serve(lis)
+	defer s.Stop()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, c.DiscoverChains(ctx, 10*time.Millisecond, 100*time.Millisecond))
+	chains := c.KnownChains()
+	require.Len(t, chains, 1)
+	require.Equal(t, NewInfoV2(info).Hash, chains[0].Hash)
+}
+
+func TestClientServesPinnedChainInfoWhenUnreachable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trust.json")
+	ts, err := LoadTrustStore(path)
+	require.NoError(t, err)
+	info := NewInfoV2(newFakeInfo("quicknet", "pinned"))
+	require.NoError(t, ts.Check(info))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := lis.Addr().String()
+	require.NoError(t, lis.Close())
+
+	c, err := NewClient("fallback:///"+addr, slog.Default(), WithTrustStore(ts))
+	require.Error(t, err)
+	defer c.Close()
+
+	got, err := c.GetChainInfo(context.Background(), &proto.Metadata{BeaconID: "quicknet"})
+	require.NoError(t, err)
+	require.Equal(t, info.Hash, got.Hash)
+	got, err = c.GetChainInfo(context.Background(), &proto.Metadata{ChainHash: info.Hash})
+	require.NoError(t, err)
+	require.Equal(t, info.Hash, got.Hash)
+
+	// only the pinned chains are served
+	_, err = c.GetChainInfo(context.Background(), &proto.Metadata{BeaconID: "evmnet"})
+	require.Error(t, err)
+
+	// also when the backends don't answer in time
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	got, err = c.GetChainInfo(ctx, &proto.Metadata{BeaconID: "quicknet"})
+	require.NoError(t, err)
+	require.Equal(t, info.Hash, got.Hash)
+}
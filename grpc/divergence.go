@@ -0,0 +1,140 @@
+package grpc
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	proto "github.com/drand/drand/v2/protobuf/drand"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WatchDivergence compares every interval the latest round and a random sample of past rounds of all the known chains
+// across all the backends, not only the picked one, until the context is cancelled. Backends more than maxLag rounds
+// behind the most advanced one, or disagreeing with the majority of backends on the content of a round, are logged
+// and counted in our metrics to get an early warning of forks, stuck nodes or misconfigurations.
+func (c *Client) WatchDivergence(ctx context.Context, interval time.Duration, maxLag uint64, samples int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.CheckDivergence(ctx, maxLag, samples)
+		}
+	}
+}
+
+// CheckDivergence runs a single divergence check of all the known chains, see WatchDivergence.
+func (c *Client) CheckDivergence(ctx context.Context, maxLag uint64, samples int) {
+	seen := make(map[string]bool)
+	c.knownChains.Range(func(_, value any) bool {
+		info, ok := value.(*JsonInfoV2)
+		if !ok || seen[info.Hash.String()] {
+			return true
+		}
+		seen[info.Hash.String()] = true
+		c.checkChainDivergence(ctx, info, maxLag, samples)
+		return ctx.Err() == nil
+	})
+}
+
+func (c *Client) checkChainDivergence(ctx context.Context, info *JsonInfoV2, maxLag uint64, samples int) {
+	m := &proto.Metadata{ChainHash: info.Hash}
+	chain := info.Hash.String()
+
+	// we first compare the latest round of every backend
+	var highest, lowest uint64
+	latest := fanOut(ctx, c.backends, func(ctx context.Context, b *backend) (*HexBeacon, []byte, error) {
+		return b.beacon(ctx, m, 0)
+	})
+	for _, v := range latest {
+		if v.err != nil {
+			c.log.Warn("divergence check unable to get latest beacon from backend", "backend", v.target, "chain", chain, "err", v.err)
+			continue
+		}
+		highest = max(highest, v.val.Round)
+		if lowest == 0 || v.val.Round < lowest {
+			lowest = v.val.Round
+		}
+	}
+	for _, v := range latest {
+		if v.err != nil {
+			continue
+		}
+		lag := highest - v.val.Round
+		BackendRoundLag.With(prometheus.Labels{"target": v.target, "chain": chain}).Set(float64(lag))
+		if lag > maxLag {
+			BackendDivergences.With(prometheus.Labels{"target": v.target, "kind": "lag"}).Inc()
+			c.log.Warn("backend divergence detected: backend is lagging behind", "kind", "lag", "backend", v.target, "chain", chain, "round", v.val.Round, "highest", highest, "lag", lag)
+		}
+	}
+	if lowest == 0 {
+		return
+	}
+
+	// then we compare the content of the latest round all backends have and of a random sample of past rounds
+	rounds := []uint64{lowest}
+	for range samples {
+		rounds = append(rounds, rand.Uint64N(lowest)+1)
+	}
+	for _, round := range rounds {
+		c.compareRound(ctx, m, chain, round)
+	}
+}
+
+// compareRound fetches a round from all the backends and reports the ones disagreeing with the largest group of
+// backends sending the same beacon.
+func (c *Client) compareRound(ctx context.Context, m *proto.Metadata, chain string, round uint64) {
+	votes := fanOut(ctx, c.backends, func(ctx context.Context, b *backend) (*HexBeacon, []byte, error) {
+		return b.beacon(ctx, m, round)
+	})
+
+	groups := make(map[string][]string)
+	majority := ""
+	for _, v := range votes {
+		if v.err != nil {
+			c.log.Debug("divergence check unable to get beacon from backend", "backend", v.target, "chain", chain, "round", round, "err", v.err)
+			continue
+		}
+		groups[v.key] = append(groups[v.key], v.target)
+		if len(groups[v.key]) > len(groups[majority]) {
+			majority = v.key
+		}
+	}
+	if len(groups) < 2 {
+		return
+	}
+
+	for key, targets := range groups {
+		if key == majority {
+			continue
+		}
+		for _, target := range targets {
+			BackendDivergences.With(prometheus.Labels{"target": target, "kind": "content"}).Inc()
+		}
+		c.log.Warn("backend divergence detected: backends disagree on beacon content", "kind", "content", "chain", chain, "round", round, "majority", groups[majority], "dissenting", targets)
+	}
+}
+
+// fanOut calls all the backends concurrently, each call being limited to the FallbackTimeout, and returns all their
+// responses in the order of the backends.
+func fanOut[T any](ctx context.Context, backends []*backend, call func(context.Context, *backend) (T, []byte, error)) []vote[T] {
+	ctx, cancel := context.WithTimeout(ctx, FallbackTimeout)
+	defer cancel()
+
+	votes := make([]vote[T], len(backends))
+	done := make(chan struct{})
+	for i, b := range backends {
+		go func() {
+			val, key, err := call(ctx, b)
+			votes[i] = vote[T]{target: b.addr, key: string(key), val: val, err: err}
+			done <- struct{}{}
+		}()
+	}
+	for range backends {
+		<-done
+	}
+	return votes
+}
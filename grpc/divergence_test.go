@@ -0,0 +1,47 @@
+package grpc
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckDivergence(t *testing.T) {
+	info := newFakeInfo("quicknet", "divergence")
+	nodes := []*fakeNode{newFakeNode(info, 10), newFakeNode(info, 10), newFakeNode(info, 30)}
+	addrs := make([]string, len(nodes))
+	for i, n := range nodes {
+		addrs[i] = n.start(t)
+	}
+	// the second node forked at round 10, which is the latest round all nodes have
+	nodes[1].setBeacon(10, []byte("forked"))
+
+	c, err := NewClient("fallback:///"+strings.Join(addrs, ","), slog.Default())
+	require.NoError(t, err)
+	defer c.Close()
+
+	c.CheckDivergence(context.Background(), 2, 3)
+
+	chain := NewInfoV2(info).Hash.String()
+	lag := func(target string) float64 {
+		return testutil.ToFloat64(BackendRoundLag.With(prometheus.Labels{"target": target, "chain": chain}))
+	}
+	divergences := func(target, kind string) float64 {
+		return testutil.ToFloat64(BackendDivergences.With(prometheus.Labels{"target": target, "kind": kind}))
+	}
+
+	require.Equal(t, float64(20), lag(addrs[0]))
+	require.Equal(t, float64(20), lag(addrs[1]))
+	require.Equal(t, float64(0), lag(addrs[2]))
+	require.Equal(t, float64(1), divergences(addrs[0], "lag"))
+	require.Equal(t, float64(0), divergences(addrs[2], "lag"))
+
+	require.Equal(t, float64(0), divergences(addrs[0], "content"))
+	require.GreaterOrEqual(t, divergences(addrs[1], "content"), float64(1))
+	require.Equal(t, float64(0), divergences(addrs[2], "content"))
+}
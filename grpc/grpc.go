@@ -3,20 +3,28 @@ package grpc
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"sync"
 	"time"
 
+	"github.com/drand/drand/v2/common"
 	proto "github.com/drand/drand/v2/protobuf/drand"
 	grpcprom "github.com/grpc-ecosystem/go-grpc-middleware/providers/prometheus"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	healthgrpc "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/protoadapt"
 )
@@ -35,6 +43,17 @@ func init() {
 	clock = time.Now
 }
 
+// SetClock replaces the clock used to compute the expected rounds, this is meant for simulated chains such as the
+// replay mode. It is not thread safe and should be called before serving any request.
+func SetClock(now func() time.Time) {
+	clock = now
+}
+
+// Now returns the current time according to the clock used to compute the expected rounds.
+func Now() time.Time {
+	return clock()
+}
+
 type logger interface {
 	Error(msg string, args ...any)
 	Warn(msg string, args ...any)
@@ -52,11 +71,24 @@ type Client struct {
 	knownChains   sync.Map
 	healthTimeout time.Duration
 	log           logger
+	trust         *TrustStore
+	backends      []*backend
+}
+
+// Option allows to enable optional features of the Client when calling NewClient.
+type Option func(*Client)
+
+// WithTrustStore makes the Client check every chain info it receives against the chain infos pinned in the provided
+// TrustStore, refusing the ones that disagree.
+func WithTrustStore(t *TrustStore) Option {
+	return func(c *Client) {
+		c.trust = t
+	}
 }
 
 // NewClient establishes a new non-TLS grpc connection to the provided server address. It takes a logger and uses
 // a default value for healthTimeout.
-func NewClient(serverAddr string, l logger) (*Client, error) {
+func NewClient(serverAddr string, l logger, opts ...Option) (*Client, error) {
 	l.Debug("NewClient", "serverAddr", serverAddr)
 
 	// setup metrics for GRPC calls
@@ -73,8 +105,7 @@ func NewClient(serverAddr string, l logger) (*Client, error) {
 	// register client metrics
 	ClientMetrics.Register(clMetrics)
 
-	conn, err := grpc.NewClient(serverAddr,
-		grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy":"logging_pick_first_with_fallback"}`),
+	dialOpts := []grpc.DialOption{
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithChainUnaryInterceptor(
 			clMetrics.UnaryClientInterceptor(),
@@ -84,6 +115,10 @@ func NewClient(serverAddr string, l logger) (*Client, error) {
 			clMetrics.StreamClientInterceptor(),
 		),
 		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	}
+
+	conn, err := grpc.NewClient(serverAddr,
+		append(dialOpts, grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy":"logging_pick_first_with_fallback"}`))...,
 	)
 	if err != nil {
 		l.Error("Unable to dial new grpc client", "err", err)
@@ -96,6 +131,21 @@ func NewClient(serverAddr string, l logger) (*Client, error) {
 		log:           l,
 	}
 
+	// we also keep a direct connection to each backend for the calls that need to query all of them, we connect them
+	// right away rather than upon their first call so that the first readiness check already sees them connected
+	for _, addr := range backendAddrs(serverAddr) {
+		bConn, err := grpc.NewClient(addr, dialOpts...)
+		if err != nil {
+			l.Error("Unable to dial backend", "backend", addr, "err", err)
+			continue
+		}
+		bConn.Connect()
+		client.backends = append(client.backends, &backend{addr: addr, conn: bConn, pc: proto.NewPublicClient(bConn)})
+	}
+	for _, opt := range opts {
+		opt(client)
+	}
+
 	// we do a GetChains call to pre-populate the knownChains, note that we have a 500ms healthTimeout built-in above
 	_, err = client.GetChains(context.Background())
 	return client, err
@@ -111,14 +161,23 @@ func (c *Client) SetTimeout(timeout time.Duration) {
 func (c *Client) Close() error {
 	c.log.Debug("Client Closing")
 
-	return c.conn.Close()
+	errs := []error{c.conn.Close()}
+	for _, b := range c.backends {
+		errs = append(errs, b.conn.Close())
+	}
+	return errors.Join(errs...)
 }
 
 // GetBeacon will fetch the requested beacon. Beacons starts at 1, asking for 0 provides the latest, asking for
 // the next one will most likely cause the server to wait until it's produced to send it your way.
+// If the context requests a quorum read, see WithQuorum, the beacon is only returned once enough backends agreed on it.
 func (c *Client) GetBeacon(ctx context.Context, m *proto.Metadata, round uint64) (*HexBeacon, error) {
 	c.log.Debug("Client GetBeacon", "round", round)
 
+	if k := QuorumFrom(ctx); k > 0 {
+		return c.quorumBeacon(ctx, m, round, k)
+	}
+
 	in := &proto.PublicRandRequest{
 		Round:    round,
 		Metadata: m,
@@ -251,6 +310,20 @@ func (info *JsonInfoV2) ExpectedNext() (expectedTime int64, expectedRound uint64
 	return current*p + info.GenesisTime, uint64(current) + 1
 }
 
+// ComputeHash recomputes the canonical chain hash from the chain info fields, the same way drand nodes do it.
+// Notice that the default beacon ID is not part of the hash to keep backward compatibility.
+func (info *JsonInfoV2) ComputeHash() []byte {
+	h := sha256.New()
+	_ = binary.Write(h, binary.BigEndian, info.Period)
+	_ = binary.Write(h, binary.BigEndian, info.GenesisTime)
+	_, _ = h.Write(info.PublicKey)
+	_, _ = h.Write(info.GenesisSeed)
+	if !common.IsDefaultBeaconID(info.BeaconId) {
+		_, _ = h.Write([]byte(info.BeaconId))
+	}
+	return h.Sum(nil)
+}
+
 func (j *JsonInfoV2) V1() *JsonInfoV1 {
 	return &JsonInfoV1{
 		PublicKey:   j.PublicKey,
@@ -277,9 +350,24 @@ func (j *JsonInfoV1) V2() *JsonInfoV2 {
 
 // GetChainInfo returns the chain info for the requested chainhash or beacon ID in the provided Metadata, the Metadata
 // should specify either a beacon ID or a chain hash, not both in order to benefit from in chain info caching.
+// If the context requests a quorum read, see WithQuorum, the chain info is only returned once enough backends agreed
+// on it, and it is cached separately from the ones obtained from a single backend.
 func (c *Client) GetChainInfo(ctx context.Context, m *proto.Metadata) (*JsonInfoV2, error) {
 	c.log.Debug("Client GetChainInfo")
 
+	if k := QuorumFrom(ctx); k > 0 {
+		key := fmt.Sprintf("quorum-%d-%x%s", k, m.GetChainHash(), m.GetBeaconID())
+		if info, ok := c.knownChains.Load(key); ok {
+			return info.(*JsonInfoV2), nil
+		}
+		info, err := c.quorumChainInfo(ctx, m, k)
+		if err != nil {
+			return nil, err
+		}
+		c.knownChains.Store(key, info)
+		return info, nil
+	}
+
 	// typically either chain hash or beacon id are set, not both, unless the API is misused
 	if info, ok := c.knownChains.Load(hex.EncodeToString(m.GetChainHash()) + m.GetBeaconID()); ok {
 		res, ok := info.(*JsonInfoV2)
@@ -291,16 +379,18 @@ func (c *Client) GetChainInfo(ctx context.Context, m *proto.Metadata) (*JsonInfo
 
 	c.log.Debug("Client GetChainInfo knownChains", "cache", "MISS")
 
-	in := &proto.ChainInfoRequest{
-		Metadata: m,
-	}
-
-	resp, err := c.pc.ChainInfo(ctx, in)
+	info, err := c.chainInfo(ctx, m)
 	if err != nil {
+		// the pinned chain infos can still be served while no backend is reachable, e.g. when starting up degraded
+		if c.trust != nil && unreachable(err) {
+			if pinned, ok := c.trust.Pinned(m); ok {
+				c.log.Warn("no backend reachable, serving the pinned chain info", "chain", pinned.Hash.String(), "err", err)
+				return pinned, nil
+			}
+		}
 		return nil, err
 	}
 
-	info := NewInfoV2(resp)
 	c.knownChains.Store(info.Hash.String(), info)
 
 	// we also have a shortcut for handling beacon IDs, which relies on the fact that we expect either chain hash
@@ -310,6 +400,85 @@ func (c *Client) GetChainInfo(ctx context.Context, m *proto.Metadata) (*JsonInfo
 	return info, err
 }
 
+// unreachable returns whether err means that no backend answered in time, rather than a backend answering an error.
+func unreachable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// chainInfo requests the chain info from a backend and checks its integrity. If a backend fails our checks, we retry
+// once with the next SubConn, deprioritizing the faulty one, before giving up.
+func (c *Client) chainInfo(ctx context.Context, m *proto.Metadata) (*JsonInfoV2, error) {
+	in := &proto.ChainInfoRequest{
+		Metadata: m,
+	}
+
+	var p peer.Peer
+	resp, err := c.pc.ChainInfo(ctx, in, grpc.Peer(&p))
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := c.checkInfo(resp, m, &p)
+	if err != nil {
+		c.log.Error("backend failed chain info integrity check, retrying with another backend", "backend", p.String(), "err", err)
+		resp, err = c.pc.ChainInfo(context.WithValue(ctx, SkipCtxKey{}, true), in, grpc.Peer(&p))
+		if err != nil {
+			return nil, err
+		}
+		info, err = c.checkInfo(resp, m, &p)
+		if err != nil {
+			c.log.Error("backend failed chain info integrity check", "backend", p.String(), "err", err)
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
+
+// checkInfo runs our integrity checks on a chain info received from the backend p for the chain requested in m,
+// reporting failures in our metrics. The chain info is only pinned once it passed our integrity checks.
+func (c *Client) checkInfo(resp *proto.ChainInfoPacket, m *proto.Metadata, p *peer.Peer) (*JsonInfoV2, error) {
+	if err := c.checkIntegrity(resp, m, p.String()); err != nil {
+		return nil, err
+	}
+
+	info := NewInfoV2(resp)
+	if err := c.checkPinned(info, p.String()); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// checkIntegrity verifies the chain hash of a chain info received from target, see verifyInfoPacket.
+func (c *Client) checkIntegrity(resp *proto.ChainInfoPacket, m *proto.Metadata, target string) error {
+	if err := verifyInfoPacket(resp, m); err != nil {
+		BackendIntegrityErrors.With(prometheus.Labels{"target": target, "reason": "chain_hash"}).Inc()
+		return err
+	}
+	return nil
+}
+
+// checkPinned compares a chain info received from target with the pinned one, if we have a TrustStore.
+func (c *Client) checkPinned(info *JsonInfoV2, target string) error {
+	if c.trust == nil {
+		return nil
+	}
+
+	err := c.trust.Check(info)
+	if errors.Is(err, ErrChainInfoMismatch) {
+		BackendIntegrityErrors.With(prometheus.Labels{"target": target, "reason": "pinned_chain_info"}).Inc()
+		return err
+	} else if err != nil {
+		// failing to persist the trust file should not prevent us from serving beacons
+		c.log.Error("unable to persist pinned chain info", "err", err)
+	}
+	return nil
+}
+
 // GetBeaconIds returns an array
 func (c *Client) GetBeaconIds(ctx context.Context) ([]string, []*proto.Metadata, error) {
 	c.log.Debug("Client GetBeaconIds")
@@ -352,27 +521,20 @@ func (c *Client) GetChains(ctx context.Context) ([]string, error) {
 			continue
 		}
 
-		in := &proto.ChainInfoRequest{
-			Metadata: &proto.Metadata{ChainHash: chain},
-		}
-
-		info, err := c.pc.ChainInfo(ctx, in)
+		info, err := c.chainInfo(ctx, &proto.Metadata{ChainHash: chain})
 		if err != nil {
 			c.log.Error("invalid call to ChainInfo", "err", err)
 			return nil, err
 		}
 
-		hash := info.GetHash()
-		if !bytes.Equal(chain, hash) {
-			return nil, fmt.Errorf("invalid chainhash %q for chain %q", hash, chain)
-		}
-		c.knownChains.Store(strChain, NewInfoV2(info))
+		// chainInfo made sure the chain hash is the one we requested and that it matches the chain info fields
+		c.knownChains.Store(strChain, info)
 
-		if id := info.GetMetadata().GetBeaconID(); id != "" {
+		if id := info.BeaconId; id != "" {
 			if beaconIds[i] != id {
-				c.log.Warn("potential mismatch of beacon ID and chain hash", "metadata", info.GetMetadata(), "index", i, "beaconIds", beaconIds, "chain", strChain)
+				c.log.Warn("potential mismatch of beacon ID and chain hash", "beaconID", id, "index", i, "beaconIds", beaconIds, "chain", strChain)
 			}
-			c.knownChains.Store(id, NewInfoV2(info))
+			c.knownChains.Store(id, info)
 		}
 	}
 
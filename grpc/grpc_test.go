@@ -74,3 +74,31 @@ func TestNextBeaconTime(t *testing.T) {
 		})
 	}
 }
+
+func TestParseInfo(t *testing.T) {
+	// the quicknet chain info, in both its V1 and V2 representations
+	v1 := `{"public_key":"83cf0f2896adee7eb8b5f01fcad3912212c437e0073e911fb90022d3e760183c8c4b450b6a0a6c3ac6a5776a2d1064510d1fec758c921cc22b0e17e63aaf4bcb5ed66304de9cf809bd274ca73bab4af5a6e9c76a4bc09e76eae8991ef5ece45a","period":3,"genesis_time":1692803367,"hash":"52db9ba70e0cc0f6eaf7803dd07447a1f5477735fd3f661792ba94600c84e971","groupHash":"f477d5c89f21a17c863a7f937c6a6d15859414d2be09cd448d4279af331c5d3e","schemeID":"bls-unchained-g1-rfc9380","metadata":{"beaconID":"quicknet"}}`
+	v2 := `{"public_key":"83cf0f2896adee7eb8b5f01fcad3912212c437e0073e911fb90022d3e760183c8c4b450b6a0a6c3ac6a5776a2d1064510d1fec758c921cc22b0e17e63aaf4bcb5ed66304de9cf809bd274ca73bab4af5a6e9c76a4bc09e76eae8991ef5ece45a","period":3,"genesis_time":1692803367,"genesis_seed":"f477d5c89f21a17c863a7f937c6a6d15859414d2be09cd448d4279af331c5d3e","chain_hash":"52db9ba70e0cc0f6eaf7803dd07447a1f5477735fd3f661792ba94600c84e971","scheme":"bls-unchained-g1-rfc9380","beacon_id":"quicknet"}`
+
+	for _, data := range []string{v1, v2} {
+		info, err := ParseInfo([]byte(data))
+		if err != nil {
+			t.Fatalf("unable to parse chain info: %v", err)
+		}
+		if err := info.VerifyHash(); err != nil {
+			t.Errorf("unexpected hash mismatch: %v", err)
+		}
+		if info.BeaconId != "quicknet" || info.Scheme != "bls-unchained-g1-rfc9380" || info.IsChained() {
+			t.Errorf("unexpected chain info: %+v", info)
+		}
+	}
+
+	info, err := ParseInfo([]byte(v2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.GenesisTime++
+	if err := info.VerifyHash(); err == nil {
+		t.Errorf("expected hash mismatch after modifying genesis time")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"log/slog"
 	"net"
+	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc"
@@ -35,6 +36,36 @@ var (
 		Name: "grpc_server_current_state",
 		Help: "Current state of the gRPC server's subchannel. 0: UNKNOWN; 1: IDLE; 2: CONNECTING; 3: READY; 4: TRANSIENT_FAILURE; 5: SHUTDOWN",
 	}, []string{"target"})
+
+	// BackendIntegrityErrors counts the responses refused because they failed our integrity checks, per backend
+	BackendIntegrityErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_client_backend_integrity_errors",
+		Help: "The total number of backend responses refused because they failed an integrity check.",
+	}, []string{"target", "reason"})
+
+	// QuorumReads counts the quorum reads, per kind of response and whether a quorum was reached
+	QuorumReads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_client_quorum_reads",
+		Help: "The total number of quorum reads, by kind of response and result.",
+	}, []string{"kind", "result"})
+
+	// QuorumDisagreements counts the responses that differed from the other backends ones during quorum reads
+	QuorumDisagreements = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_client_quorum_disagreements",
+		Help: "The total number of backend responses disagreeing with the other backends during quorum reads.",
+	}, []string{"target", "kind"})
+
+	// BackendDivergences counts the divergences detected by the background divergence checks, per backend and kind
+	BackendDivergences = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_client_backend_divergences",
+		Help: "The total number of divergences from the other backends detected, by kind: lag or content.",
+	}, []string{"target", "kind"})
+
+	// BackendRoundLag is the number of rounds a backend is behind the most advanced backend for a chain
+	BackendRoundLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "grpc_client_backend_round_lag",
+		Help: "The number of rounds a backend is behind the most advanced backend, as of the last divergence check.",
+	}, []string{"target", "chain"})
 )
 
 type LocalMetricClient struct {
@@ -53,6 +84,11 @@ func bindMetrics() error {
 		grpcServerCallsStartedTotal,
 		grpcServerLastCallStartedSeconds,
 		grpcServerCurrentState,
+		BackendIntegrityErrors,
+		QuorumReads,
+		QuorumDisagreements,
+		BackendDivergences,
+		BackendRoundLag,
 	}
 	for _, c := range g {
 		if err := ClientMetrics.Register(c); err != nil {
@@ -104,6 +140,11 @@ func UpdateMetrics(metricClient *LocalMetricClient) string {
 
 	ret := make([]*grpc_channelz_v1.GetSubchannelResponse, 0)
 	for _, respCh := range resp.GetChannel() {
+		// the direct backend connections used to query all backends would otherwise overwrite the metrics of the
+		// subchannels of our fallback connection for the same targets
+		if !strings.HasPrefix(respCh.GetData().GetTarget(), FallbackResolverName+":") {
+			continue
+		}
 		for _, sc := range respCh.GetSubchannelRef() {
 			subr, err := metricClient.GetSubchannel(context.Background(), &grpc_channelz_v1.GetSubchannelRequest{SubchannelId: sc.GetSubchannelId()})
 			if err != nil {
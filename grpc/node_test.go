@@ -0,0 +1,117 @@
+package grpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/drand/drand/v2/crypto"
+	proto "github.com/drand/drand/v2/protobuf/drand"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeNode is a minimal drand node serving a single chain from memory, meant to test the Client against real grpc
+// connections. Its beacons are not properly signed.
+type fakeNode struct {
+	proto.UnimplementedPublicServer
+
+	mu      sync.Mutex
+	info    *proto.ChainInfoPacket
+	beacons map[uint64]*proto.PublicRandResponse
+	latest  uint64
+}
+
+// newFakeInfo returns a chain info packet with a valid chain hash and public key, the seed allowing to create
+// different chains.
+func newFakeInfo(beaconID, seed string) *proto.ChainInfoPacket {
+	sch, err := crypto.GetSchemeByID("bls-unchained-g1-rfc9380")
+	if err != nil {
+		panic(err)
+	}
+	secret := sha256.Sum256([]byte(seed))
+	pub, err := sch.KeyGroup.Point().Mul(sch.KeyGroup.Scalar().SetBytes(secret[:]), nil).MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	info := &JsonInfoV2{
+		PublicKey:   pub,
+		Period:      3,
+		GenesisTime: 1700000000,
+		GenesisSeed: []byte(seed),
+		Scheme:      sch.Name,
+		BeaconId:    beaconID,
+	}
+	hash := info.ComputeHash()
+	return &proto.ChainInfoPacket{
+		PublicKey:   info.PublicKey,
+		Period:      info.Period,
+		GenesisTime: info.GenesisTime,
+		Hash:        hash,
+		GroupHash:   info.GenesisSeed,
+		SchemeID:    info.Scheme,
+		Metadata:    &proto.Metadata{BeaconID: beaconID, ChainHash: hash},
+	}
+}
+
+// newFakeNode returns a fakeNode serving the provided chain with rounds 1 to latest.
+func newFakeNode(info *proto.ChainInfoPacket, latest uint64) *fakeNode {
+	n := &fakeNode{info: info, beacons: make(map[uint64]*proto.PublicRandResponse)}
+	for r := uint64(1); r <= latest; r++ {
+		n.setBeacon(r, []byte{byte(r)})
+	}
+	return n
+}
+
+func (n *fakeNode) setBeacon(round uint64, sig []byte) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.beacons[round] = &proto.PublicRandResponse{Round: round, Signature: sig}
+	n.latest = max(n.latest, round)
+}
+
+// start serves the node on a random localhost port until the end of the test and returns its address.
+func (n *fakeNode) start(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	proto.RegisterPublicServer(s, n)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+func (n *fakeNode) PublicRand(_ context.Context, in *proto.PublicRandRequest) (*proto.PublicRandResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	round := in.GetRound()
+	if round == 0 {
+		round = n.latest
+	}
+	b, ok := n.beacons[round]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "round %d not found", round)
+	}
+	return b, nil
+}
+
+func (n *fakeNode) ChainInfo(context.Context, *proto.ChainInfoRequest) (*proto.ChainInfoPacket, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.info, nil
+}
+
+func (n *fakeNode) ListBeaconIDs(context.Context, *proto.ListBeaconIDsRequest) (*proto.ListBeaconIDsResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return &proto.ListBeaconIDsResponse{
+		Ids:       []string{n.info.GetMetadata().GetBeaconID()},
+		Metadatas: []*proto.Metadata{{BeaconID: n.info.GetMetadata().GetBeaconID(), ChainHash: n.info.GetHash()}},
+	}, nil
+}
@@ -0,0 +1,200 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	proto "github.com/drand/drand/v2/protobuf/drand"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+)
+
+// ErrNoQuorum is returned when not enough backends agreed on a response for a quorum read.
+var ErrNoQuorum = errors.New("no quorum reached among backends")
+
+// QuorumCtxKey is the context key used to request quorum reads, its value is the number of backends that need to
+// send byte-identical responses for the Client to return them. See WithQuorum.
+type QuorumCtxKey struct{}
+
+// WithQuorum returns a context requesting the GetBeacon and GetChainInfo calls made with it to query all the backends
+// concurrently and to only return a response once at least k of them agreed on it. A k of 0 disables quorum reads.
+func WithQuorum(ctx context.Context, k int) context.Context {
+	return context.WithValue(ctx, QuorumCtxKey{}, k)
+}
+
+// QuorumFrom returns the quorum requested in the provided context, 0 meaning that no quorum read was requested.
+func QuorumFrom(ctx context.Context) int {
+	k, _ := ctx.Value(QuorumCtxKey{}).(int)
+	return max(k, 0)
+}
+
+// backend is a direct connection to a single one of the backends of the fallback list, bypassing the fallback
+// balancer. These are used when we need to query all the backends rather than the picked one.
+type backend struct {
+	addr string
+	conn *grpc.ClientConn
+	pc   proto.PublicClient
+}
+
+// beacon requests a beacon from the backend and returns it along with its canonical encoding, used to compare the
+// beacons sent by different backends.
+func (b *backend) beacon(ctx context.Context, m *proto.Metadata, round uint64) (*HexBeacon, []byte, error) {
+	resp, err := b.pc.PublicRand(ctx, &proto.PublicRandRequest{Round: round, Metadata: m})
+	if err != nil {
+		return nil, nil, err
+	}
+	beacon := NewHexBeacon(resp)
+	// the randomness is derived from the signature and not sent by all nodes, it isn't part of what we compare
+	beacon.UnsetRandomness()
+	key, err := json.Marshal(beacon)
+	return beacon, key, err
+}
+
+// backendAddrs returns the list of backend addresses from the address used to create a Client, which can be either a
+// single address or a fallback:/// list of addresses.
+func backendAddrs(serverAddr string) []string {
+	return strings.Split(strings.TrimPrefix(serverAddr, FallbackResolverName+":///"), ",")
+}
+
+// Backends returns the number of backends the Client can query, which is the maximum quorum it supports.
+func (c *Client) Backends() int {
+	return len(c.backends)
+}
+
+// vote is the response of a single backend in a quorum read, its key being the canonical encoding of its value.
+type vote[T any] struct {
+	target string
+	key    string
+	val    T
+	err    error
+}
+
+// quorum calls all the backends concurrently and returns the first value for which at least k backends returned the
+// same key, cancelling the calls still running at that point. Backends that returned another key are reported as
+// disagreeing with the quorum.
+func quorum[T any](ctx context.Context, l logger, backends []*backend, k int, kind string, call func(context.Context, *backend) (T, []byte, error)) (T, error) {
+	var zero T
+	if k > len(backends) {
+		QuorumReads.With(prometheus.Labels{"kind": kind, "result": "failed"}).Inc()
+		return zero, fmt.Errorf("%w: quorum of %d requested but only %d backends are configured", ErrNoQuorum, k, len(backends))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	votes := make(chan vote[T], len(backends))
+	for _, b := range backends {
+		go func() {
+			val, key, err := call(ctx, b)
+			votes <- vote[T]{target: b.addr, key: string(key), val: val, err: err}
+		}()
+	}
+
+	groups := make(map[string][]string)
+	var errs []error
+	best := 0
+	for received := 1; received <= len(backends); received++ {
+		v := <-votes
+		if v.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", v.target, v.err))
+		} else {
+			groups[v.key] = append(groups[v.key], v.target)
+			best = max(best, len(groups[v.key]))
+			if len(groups[v.key]) >= k {
+				reportDisagreements(l, groups, v.key, kind)
+				QuorumReads.With(prometheus.Labels{"kind": kind, "result": "ok"}).Inc()
+				return v.val, nil
+			}
+		}
+		// we stop early when the pending backends cannot make any response reach the quorum anymore
+		if best+len(backends)-received < k {
+			break
+		}
+	}
+
+	reportDisagreements(l, groups, "", kind)
+	QuorumReads.With(prometheus.Labels{"kind": kind, "result": "failed"}).Inc()
+	err := fmt.Errorf("%w: at most %d backends agreed on %s out of the %d required", ErrNoQuorum, best, kind, k)
+	if len(errs) > 0 {
+		err = fmt.Errorf("%w, errors: %w", err, errors.Join(errs...))
+	}
+	return zero, err
+}
+
+// reportDisagreements logs and counts the backends whose response differs from the one with the quorum key. When no
+// quorum was reached, all the backends are reported as soon as there is more than one distinct response.
+func reportDisagreements(l logger, groups map[string][]string, quorumKey, kind string) {
+	if len(groups) < 2 {
+		return
+	}
+	for key, targets := range groups {
+		if key == quorumKey {
+			continue
+		}
+		for _, target := range targets {
+			QuorumDisagreements.With(prometheus.Labels{"target": target, "kind": kind}).Inc()
+		}
+		l.Warn("backends disagree on quorum read", "kind", kind, "quorum", groups[quorumKey], "dissenting", targets)
+	}
+}
+
+// quorumBeacon is the quorum read version of GetBeacon. Since backends may be a few milliseconds apart when a new
+// round is emitted, a quorum read for the latest beacon is retried once for the lowest latest round received.
+func (c *Client) quorumBeacon(ctx context.Context, m *proto.Metadata, round uint64, k int) (*HexBeacon, error) {
+	var mu sync.Mutex
+	var lowest uint64
+	fetch := func(round uint64) func(context.Context, *backend) (*HexBeacon, []byte, error) {
+		return func(ctx context.Context, b *backend) (*HexBeacon, []byte, error) {
+			beacon, key, err := b.beacon(ctx, m, round)
+			if err != nil {
+				return nil, nil, err
+			}
+			mu.Lock()
+			if lowest == 0 || beacon.Round < lowest {
+				lowest = beacon.Round
+			}
+			mu.Unlock()
+			return beacon, key, nil
+		}
+	}
+
+	beacon, err := quorum(ctx, c.log, c.backends, k, "beacon", fetch(round))
+	if err == nil || round != 0 {
+		return beacon, err
+	}
+
+	mu.Lock()
+	latest := lowest
+	mu.Unlock()
+	if latest == 0 {
+		return nil, err
+	}
+	c.log.Debug("quorum read for latest beacon failed, retrying for lowest latest round", "round", latest)
+	return quorum(ctx, c.log, c.backends, k, "beacon", fetch(latest))
+}
+
+// quorumChainInfo is the quorum read version of chainInfo, every chain info received is checked for integrity before
+// being compared, and only the one agreed upon is checked against the pinned chain infos.
+func (c *Client) quorumChainInfo(ctx context.Context, m *proto.Metadata, k int) (*JsonInfoV2, error) {
+	info, err := quorum(ctx, c.log, c.backends, k, "chain_info", func(ctx context.Context, b *backend) (*JsonInfoV2, []byte, error) {
+		resp, err := b.pc.ChainInfo(ctx, &proto.ChainInfoRequest{Metadata: m})
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := c.checkIntegrity(resp, m, b.addr); err != nil {
+			return nil, nil, err
+		}
+		info := NewInfoV2(resp)
+		key, err := json.Marshal(info)
+		return info, key, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return info, c.checkPinned(info, "quorum")
+}
@@ -0,0 +1,73 @@
+package grpc
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	proto "github.com/drand/drand/v2/protobuf/drand"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuorumReads(t *testing.T) {
+	info := newFakeInfo("quicknet", "quorum")
+	nodes := []*fakeNode{newFakeNode(info, 10), newFakeNode(info, 10), newFakeNode(info, 11)}
+	addrs := make([]string, len(nodes))
+	for i, n := range nodes {
+		addrs[i] = n.start(t)
+	}
+	// the last node serves a different beacon for round 5
+	nodes[2].setBeacon(5, []byte("forged"))
+
+	c, err := NewClient("fallback:///"+strings.Join(addrs, ","), slog.Default())
+	require.NoError(t, err)
+	defer c.Close()
+	require.Equal(t, 3, c.Backends())
+
+	m := &proto.Metadata{BeaconID: "quicknet"}
+	ctx := context.Background()
+
+	b, err := c.GetBeacon(WithQuorum(ctx, 2), m, 5)
+	require.NoError(t, err)
+	require.Equal(t, []byte{5}, []byte(b.Signature))
+
+	_, err = c.GetBeacon(WithQuorum(ctx, 3), m, 5)
+	require.ErrorIs(t, err, ErrNoQuorum)
+
+	b, err = c.GetBeacon(WithQuorum(ctx, 3), m, 4)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), b.Round)
+
+	// the nodes disagree on the latest round, so we fall back to the lowest one they all have
+	b, err = c.GetBeacon(WithQuorum(ctx, 3), m, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), b.Round)
+
+	_, err = c.GetBeacon(WithQuorum(ctx, 4), m, 4)
+	require.ErrorIs(t, err, ErrNoQuorum)
+
+	got, err := c.GetChainInfo(WithQuorum(ctx, 3), m)
+	require.NoError(t, err)
+	require.Equal(t, info.GetHash(), []byte(got.Hash))
+}
+
+func TestQuorumChainInfoDisagreement(t *testing.T) {
+	good := newFakeInfo("quicknet", "good")
+	a := newFakeNode(good, 1).start(t)
+	b := newFakeNode(good, 1).start(t)
+	// a node serving another quicknet chain, with a valid chain hash
+	other := newFakeNode(newFakeInfo("quicknet", "other"), 1).start(t)
+
+	c, err := NewClient("fallback:///"+a+","+other+","+b, slog.Default())
+	require.NoError(t, err)
+	defer c.Close()
+
+	m := &proto.Metadata{BeaconID: "quicknet"}
+	got, err := c.GetChainInfo(WithQuorum(context.Background(), 2), m)
+	require.NoError(t, err)
+	require.Equal(t, good.GetHash(), []byte(got.Hash))
+
+	_, err = c.GetChainInfo(WithQuorum(context.Background(), 3), m)
+	require.ErrorIs(t, err, ErrNoQuorum)
+}
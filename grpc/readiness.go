@@ -0,0 +1,84 @@
+package grpc
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+
+	proto "github.com/drand/drand/v2/protobuf/drand"
+	"google.golang.org/grpc/connectivity"
+)
+
+// BackendReadiness is the state of a backend as reported by Client.Readiness.
+type BackendReadiness struct {
+	Addr  string `json:"addr"`
+	State string `json:"state"`
+	// Lag is the number of rounds the backend is behind the expected current round, per chain hash.
+	Lag   map[string]uint64 `json:"lag,omitempty"`
+	Ready bool              `json:"ready"`
+	Error string            `json:"error,omitempty"`
+}
+
+// KnownChains returns the chain infos loaded so far, without querying the backends.
+func (c *Client) KnownChains() []*JsonInfoV2 {
+	seen := make(map[string]bool)
+	var infos []*JsonInfoV2
+	c.knownChains.Range(func(_, value any) bool {
+		info, ok := value.(*JsonInfoV2)
+		if ok && !seen[info.Hash.String()] {
+			seen[info.Hash.String()] = true
+			infos = append(infos, info)
+		}
+		return true
+	})
+	slices.SortFunc(infos, func(a, b *JsonInfoV2) int { return cmp.Compare(a.Hash.String(), b.Hash.String()) })
+	return infos
+}
+
+// Readiness reports whether each backend is connected and at most maxLag rounds behind the expected current round of
+// every known chain. The backends whose connection went idle are asked to reconnect, to be ready for the next check.
+func (c *Client) Readiness(ctx context.Context, maxLag uint64) []BackendReadiness {
+	chains := c.KnownChains()
+	res := make([]BackendReadiness, len(c.backends))
+	var wg sync.WaitGroup
+	for i, b := range c.backends {
+		state := b.conn.GetState()
+		res[i] = BackendReadiness{Addr: b.addr, State: state.String()}
+		if state == connectivity.Idle {
+			b.conn.Connect()
+		}
+		if state != connectivity.Ready {
+			continue
+		}
+
+		wg.Add(1)
+		go func(r *BackendReadiness) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, c.healthTimeout)
+			defer cancel()
+
+			r.Ready = true
+			r.Lag = make(map[string]uint64, len(chains))
+			for _, info := range chains {
+				beacon, _, err := b.beacon(ctx, &proto.Metadata{ChainHash: info.Hash}, 0)
+				if err != nil {
+					r.Ready = false
+					r.Error = err.Error()
+					return
+				}
+				_, next := info.ExpectedNext()
+				var lag uint64
+				if current := next - 1; current > beacon.GetRound() {
+					lag = current - beacon.GetRound()
+				}
+				r.Lag[info.Hash.String()] = lag
+				if lag > maxLag {
+					r.Ready = false
+				}
+			}
+		}(&res[i])
+	}
+	wg.Wait()
+	return res
+}
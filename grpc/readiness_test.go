@@ -0,0 +1,47 @@
+package grpc
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/connectivity"
+)
+
+func TestReadiness(t *testing.T) {
+	info := newFakeInfo("quicknet", "readiness")
+	nodes := []*fakeNode{newFakeNode(info, 10), newFakeNode(info, 5)}
+	addrs := make([]string, len(nodes))
+	for i, n := range nodes {
+		addrs[i] = n.start(t)
+	}
+	// the expected current round is 10
+	SetClock(func() time.Time { return time.Unix(info.GetGenesisTime()+9*int64(info.GetPeriod()), 0) })
+	t.Cleanup(func() { SetClock(time.Now) })
+
+	c, err := NewClient("fallback:///"+strings.Join(addrs, ",")+",127.0.0.1:1", slog.Default())
+	require.NoError(t, err)
+	defer c.Close()
+
+	chains := c.KnownChains()
+	require.Len(t, chains, 1)
+	require.Equal(t, NewInfoV2(info).Hash, chains[0].Hash)
+	chain := chains[0].Hash.String()
+
+	// the backend connections are established upfront, without waiting for a readiness check
+	require.Eventually(t, func() bool {
+		return c.backends[0].conn.GetState() == connectivity.Ready && c.backends[1].conn.GetState() == connectivity.Ready
+	}, 5*time.Second, 10*time.Millisecond)
+	backends := c.Readiness(context.Background(), 2)
+	require.True(t, backends[0].Ready)
+
+	require.Equal(t, addrs[0], backends[0].Addr)
+	require.Equal(t, map[string]uint64{chain: 0}, backends[0].Lag)
+	require.False(t, backends[1].Ready)
+	require.Equal(t, map[string]uint64{chain: 5}, backends[1].Lag)
+	require.False(t, backends[2].Ready)
+	require.NotEqual(t, "READY", backends[2].State)
+}
@@ -0,0 +1,140 @@
+package grpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/drand/drand/v2/common"
+	proto "github.com/drand/drand/v2/protobuf/drand"
+)
+
+// ErrChainInfoMismatch is returned when a backend sends a chain info that disagrees with the pinned one.
+var ErrChainInfoMismatch = errors.New("chain info mismatch with pinned chain info")
+
+// TrustStore pins the first chain info seen for every chain hash and beacon ID, and persists them to a local file so
+// that they survive restarts. Any later chain info disagreeing with a pinned one is refused.
+// Note that relaunching a network using the same beacon ID requires to remove it from the trust file.
+type TrustStore struct {
+	path string
+
+	mu     sync.Mutex
+	chains map[string]*JsonInfoV2
+	ids    map[string]string
+}
+
+// LoadTrustStore loads the trust file at the provided path, it is created on the first pinned chain if it doesn't exist.
+func LoadTrustStore(path string) (*TrustStore, error) {
+	t := &TrustStore{
+		path:   path,
+		chains: make(map[string]*JsonInfoV2),
+		ids:    make(map[string]string),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var infos []*JsonInfoV2
+	if err := json.Unmarshal(data, &infos); err != nil {
+		return nil, fmt.Errorf("invalid trust file %s: %w", path, err)
+	}
+	for _, info := range infos {
+		t.pin(info)
+	}
+
+	return t, nil
+}
+
+func (t *TrustStore) pin(info *JsonInfoV2) {
+	hash := info.Hash.String()
+	t.chains[hash] = info
+	t.ids[common.GetCanonicalBeaconID(info.BeaconId)] = hash
+}
+
+// Check compares the provided chain info with the pinned one for the same chain hash and beacon ID, and pins it if we
+// never saw any of them before. It returns an ErrChainInfoMismatch error if it disagrees with a pinned chain info.
+func (t *TrustStore) Check(info *JsonInfoV2) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	hash := info.Hash.String()
+	id := common.GetCanonicalBeaconID(info.BeaconId)
+
+	if pinnedHash, ok := t.ids[id]; ok && pinnedHash != hash {
+		return fmt.Errorf("%w: beacon ID %q is pinned to chain %s, got %s", ErrChainInfoMismatch, id, pinnedHash, hash)
+	}
+
+	pinned, ok := t.chains[hash]
+	if !ok {
+		t.pin(info)
+		return t.save()
+	}
+
+	switch {
+	case !bytes.Equal(pinned.PublicKey, info.PublicKey):
+		return fmt.Errorf("%w: public key differs for chain %s", ErrChainInfoMismatch, hash)
+	case pinned.Period != info.Period:
+		return fmt.Errorf("%w: period %d differs from pinned %d for chain %s", ErrChainInfoMismatch, info.Period, pinned.Period, hash)
+	case pinned.GenesisTime != info.GenesisTime:
+		return fmt.Errorf("%w: genesis time %d differs from pinned %d for chain %s", ErrChainInfoMismatch, info.GenesisTime, pinned.GenesisTime, hash)
+	case pinned.Scheme != info.Scheme:
+		return fmt.Errorf("%w: scheme %q differs from pinned %q for chain %s", ErrChainInfoMismatch, info.Scheme, pinned.Scheme, hash)
+	case !common.CompareBeaconIDs(pinned.BeaconId, info.BeaconId):
+		return fmt.Errorf("%w: beacon ID %q differs from pinned %q for chain %s", ErrChainInfoMismatch, info.BeaconId, pinned.BeaconId, hash)
+	}
+
+	return nil
+}
+
+// Pinned returns the pinned chain info for the chain hash or beacon ID requested in m, if any.
+func (t *TrustStore) Pinned(m *proto.Metadata) (*JsonInfoV2, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	hash := hex.EncodeToString(m.GetChainHash())
+	if hash == "" {
+		hash = t.ids[common.GetCanonicalBeaconID(m.GetBeaconID())]
+	}
+	info, ok := t.chains[hash]
+	return info, ok
+}
+
+// save atomically writes all the pinned chain infos to the trust file, it must be called with the lock held.
+func (t *TrustStore) save() error {
+	infos := make([]*JsonInfoV2, 0, len(t.chains))
+	for _, info := range t.chains {
+		infos = append(infos, info)
+	}
+	// we keep a stable ordering to make the trust file easy to diff
+	slices.SortFunc(infos, func(a, b *JsonInfoV2) int {
+		return bytes.Compare(a.Hash, b.Hash)
+	})
+	data, err := json.MarshalIndent(infos, "", jsonIndent)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(t.path), filepath.Base(t.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("unable to persist trust file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to persist trust file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to persist trust file: %w", err)
+	}
+	return os.Rename(tmp.Name(), t.path)
+}
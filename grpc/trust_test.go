@@ -0,0 +1,113 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	proto "github.com/drand/drand/v2/protobuf/drand"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustStorePinsFirstChainInfo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trust.json")
+	ts, err := LoadTrustStore(path)
+	require.NoError(t, err)
+
+	info := NewInfoV2(newFakeInfo("quicknet", "a"))
+	require.NoError(t, ts.Check(info))
+	require.NoError(t, ts.Check(info))
+
+	// the pinned chain info survives a reload
+	ts, err = LoadTrustStore(path)
+	require.NoError(t, err)
+	require.NoError(t, ts.Check(info))
+
+	other := NewInfoV2(newFakeInfo("quicknet", "b"))
+	require.ErrorIs(t, ts.Check(other), ErrChainInfoMismatch)
+
+	modified := *info
+	modified.Period = 30
+	require.ErrorIs(t, ts.Check(&modified), ErrChainInfoMismatch)
+
+	modified = *info
+	modified.Scheme = "pedersen-bls-chained"
+	require.ErrorIs(t, ts.Check(&modified), ErrChainInfoMismatch)
+
+	// a new chain with another beacon ID gets pinned too
+	require.NoError(t, ts.Check(NewInfoV2(newFakeInfo("evmnet", "c"))))
+}
+
+func TestClientRefusesBackendDisagreeingWithPinnedChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trust.json")
+	ts, err := LoadTrustStore(path)
+	require.NoError(t, err)
+
+	good := newFakeNode(newFakeInfo("quicknet", "good"), 10).start(t)
+	c, err := NewClient("fallback:///"+good, slog.Default(), WithTrustStore(ts))
+	require.NoError(t, err)
+	defer c.Close()
+
+	// a node pointed at the wrong group cannot poison a relay that restarted
+	ts, err = LoadTrustStore(path)
+	require.NoError(t, err)
+	bad := newFakeNode(newFakeInfo("quicknet", "bad"), 10).start(t)
+	c2, err := NewClient("fallback:///"+bad, slog.Default(), WithTrustStore(ts))
+	require.True(t, errors.Is(err, ErrChainInfoMismatch), "unexpected error: %v", err)
+	defer c2.Close()
+
+	_, err = c2.GetChainInfo(context.Background(), &proto.Metadata{BeaconID: "quicknet"})
+	require.ErrorIs(t, err, ErrChainInfoMismatch)
+}
+
+func TestVerifyInfoPacket(t *testing.T) {
+	good := newFakeInfo("quicknet", "good")
+	require.NoError(t, verifyInfoPacket(good, &proto.Metadata{BeaconID: "quicknet"}))
+	require.NoError(t, verifyInfoPacket(good, &proto.Metadata{ChainHash: good.GetHash()}))
+
+	// asking for another chain
+	other := newFakeInfo("quicknet", "other")
+	require.ErrorIs(t, verifyInfoPacket(good, &proto.Metadata{ChainHash: other.GetHash()}), ErrBackendIntegrity)
+	require.ErrorIs(t, verifyInfoPacket(good, &proto.Metadata{BeaconID: "evmnet"}), ErrBackendIntegrity)
+
+	tampered := newFakeInfo("quicknet", "good")
+	tampered.Period = 30
+	require.ErrorIs(t, verifyInfoPacket(tampered, &proto.Metadata{BeaconID: "quicknet"}), ErrBackendIntegrity)
+
+	tampered = newFakeInfo("quicknet", "good")
+	tampered.Metadata.ChainHash = other.GetHash()
+	require.ErrorIs(t, verifyInfoPacket(tampered, &proto.Metadata{BeaconID: "quicknet"}), ErrBackendIntegrity)
+
+	// the scheme is not part of the chain hash, but the public key must belong to its key group
+	tampered = newFakeInfo("quicknet", "good")
+	tampered.SchemeID = "bls-unchained-on-g1"
+	require.NoError(t, verifyInfoPacket(tampered, &proto.Metadata{BeaconID: "quicknet"}))
+	tampered.SchemeID = "pedersen-bls-chained"
+	require.ErrorIs(t, verifyInfoPacket(tampered, &proto.Metadata{BeaconID: "quicknet"}), ErrBackendIntegrity)
+	tampered.SchemeID = "unknown-scheme"
+	require.ErrorIs(t, verifyInfoPacket(tampered, &proto.Metadata{BeaconID: "quicknet"}), ErrBackendIntegrity)
+}
+
+func TestClientRefusesBackendWithInvalidChainHash(t *testing.T) {
+	// the tampered backend advertises the genuine chain hash, but with another genesis time
+	expected := newFakeInfo("quicknet", "good")
+	info := newFakeInfo("quicknet", "good")
+	info.GenesisTime++
+	bad := newFakeNode(info, 10).start(t)
+	good := newFakeNode(expected, 10).start(t)
+
+	// the tampered backend is skipped in favour of the next one
+	c, err := NewClient("fallback:///"+bad+","+good, slog.Default())
+	require.NoError(t, err)
+	defer c.Close()
+
+	got, err := c.GetChainInfo(context.Background(), &proto.Metadata{BeaconID: "quicknet"})
+	require.NoError(t, err)
+	require.Equal(t, expected.GetGenesisTime(), got.GenesisTime)
+
+	c2, err := NewClient("fallback:///"+bad, slog.Default())
+	require.ErrorIs(t, err, ErrBackendIntegrity)
+	defer c2.Close()
+}
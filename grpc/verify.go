@@ -0,0 +1,96 @@
+package grpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/drand/drand/v2/common"
+	"github.com/drand/drand/v2/crypto"
+	proto "github.com/drand/drand/v2/protobuf/drand"
+)
+
+// ErrBackendIntegrity is returned when a backend response fails our integrity checks.
+var ErrBackendIntegrity = errors.New("backend integrity error")
+
+// verifyInfoPacket checks that the chain hash of a chain info packet is the one computed from its fields, that it is
+// consistent with its metadata and with the chain requested in m, and that its public key is valid for its scheme.
+func verifyInfoPacket(resp *proto.ChainInfoPacket, m *proto.Metadata) error {
+	info := NewInfoV2(resp)
+	computed := info.ComputeHash()
+
+	switch {
+	case !bytes.Equal(resp.GetHash(), computed):
+		return fmt.Errorf("%w: chain hash %x does not match the chain info fields, expected %x", ErrBackendIntegrity, resp.GetHash(), computed)
+	case !bytes.Equal(info.Hash, computed):
+		return fmt.Errorf("%w: metadata chain hash %x does not match the chain info fields, expected %x", ErrBackendIntegrity, []byte(info.Hash), computed)
+	case len(m.GetChainHash()) > 0 && !bytes.Equal(m.GetChainHash(), computed):
+		return fmt.Errorf("%w: requested chain %x but got chain %x", ErrBackendIntegrity, m.GetChainHash(), computed)
+	case len(m.GetChainHash()) == 0 && !common.CompareBeaconIDs(m.GetBeaconID(), info.BeaconId):
+		return fmt.Errorf("%w: requested beacon ID %q but got beacon ID %q", ErrBackendIntegrity, m.GetBeaconID(), info.BeaconId)
+	}
+
+	if _, err := info.Verifier(); err != nil {
+		return fmt.Errorf("%w: %w", ErrBackendIntegrity, err)
+	}
+
+	return nil
+}
+
+// IsChained returns whether the beacons of that chain are linked to their previous round.
+func (info *JsonInfoV2) IsChained() bool {
+	return info.Scheme == "" || info.Scheme == crypto.DefaultSchemeID
+}
+
+// Verifier parses the chain scheme and public key once and returns a function checking the signature of beacons
+// from that chain. It does not check the links between rounds on chained schemes.
+func (info *JsonInfoV2) Verifier() (func(b *HexBeacon) error, error) {
+	sch, err := crypto.GetSchemeByID(info.Scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	pub := sch.KeyGroup.Point()
+	if err := pub.UnmarshalBinary(info.PublicKey); err != nil {
+		return nil, fmt.Errorf("invalid public key for scheme %s: %w", sch.Name, err)
+	}
+
+	return func(b *HexBeacon) error {
+		if err := sch.VerifyBeacon(b, pub); err != nil {
+			return fmt.Errorf("invalid signature for round %d: %w", b.Round, err)
+		}
+		return nil
+	}, nil
+}
+
+// VerifyHash checks that the chain hash is matching the one computed from the chain info fields.
+func (info *JsonInfoV2) VerifyHash() error {
+	if computed := info.ComputeHash(); !bytes.Equal(computed, info.Hash) {
+		return fmt.Errorf("chain hash %x does not match the chain info, expected %x", []byte(info.Hash), computed)
+	}
+	return nil
+}
+
+// ParseInfo decodes a chain info in either its V1 or V2 JSON representation.
+func ParseInfo(data []byte) (*JsonInfoV2, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("invalid chain info json: %w", err)
+	}
+
+	// the chain_hash key only exists in the V2 representation, V1 uses hash
+	if _, ok := fields["chain_hash"]; ok {
+		info := new(JsonInfoV2)
+		if err := json.Unmarshal(data, info); err != nil {
+			return nil, fmt.Errorf("invalid V2 chain info: %w", err)
+		}
+		return info, nil
+	}
+
+	v1 := new(JsonInfoV1)
+	if err := json.Unmarshal(data, v1); err != nil {
+		return nil, fmt.Errorf("invalid V1 chain info: %w", err)
+	}
+	return v1.V2(), nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/drand/http-relay/grpc"
+)
+
+const (
+	// readyTimeout bounds how long a /readyz check can query the backends, load balancers usually timing out quickly.
+	readyTimeout = 2 * time.Second
+	// readyCacheTTL is how long the backend checks of /readyz are reused for, since anyone can probe it and each check
+	// queries every backend.
+	readyCacheTTL = time.Second
+)
+
+// startTime is when the relay started, reported by /livez.
+var startTime = time.Now()
+
+// healthCheck is the result of one of the checks of /readyz or /livez.
+type healthCheck struct {
+	OK     bool   `json:"ok"`
+	Detail any    `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// writeHealth answers with the checks and a 200 if they all passed, a 503 otherwise so that load balancers act on it.
+func writeHealth(w http.ResponseWriter, checks map[string]healthCheck) {
+	status, code := "ok", http.StatusOK
+	for _, c := range checks {
+		if !c.OK {
+			status, code = "fail", http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Status string                 `json:"status"`
+		Checks map[string]healthCheck `json:"checks"`
+	}{status, checks})
+}
+
+// Livez reports whether the relay process is alive, it doesn't depend on the backends so that orchestrators don't
+// restart relays over a backend outage.
+func Livez(w http.ResponseWriter, _ *http.Request) {
+	writeHealth(w, map[string]healthCheck{
+		"process": {OK: true, Detail: map[string]any{
+			"version":    version,
+			"uptime":     time.Since(startTime).Round(time.Second).String(),
+			"goroutines": runtime.NumGoroutine(),
+		}},
+	})
+}
+
+// Readyz reports whether the relay can serve requests: it must not be shutting down, it must have loaded the chain
+// infos and, with the grpc client, at least one backend must be connected and at most --ready-max-lag rounds behind.
+// The backend checks are cached for readyCacheTTL, see readyCache.
+func Readyz(client Client) http.HandlerFunc {
+	cache := &readyCache{client: client}
+	return func(w http.ResponseWriter, r *http.Request) {
+		checks := map[string]healthCheck{"shutdown": {OK: true}}
+		for name, check := range cache.get(r.Context()) {
+			checks[name] = check
+		}
+		if shuttingDown.Load() {
+			checks["shutdown"] = healthCheck{Error: "relay shutting down"}
+		}
+		writeHealth(w, checks)
+	}
+}
+
+// readyCache caches the backend checks of /readyz. A single check runs at a time, without holding the lock, and the
+// probes arriving meanwhile get the previous result, or wait for that check if there is none yet.
+type readyCache struct {
+	client Client
+
+	mu      sync.Mutex
+	checks  map[string]healthCheck
+	expires time.Time
+	// running is closed once the running check, if any, completes
+	running chan struct{}
+}
+
+// get returns the cached backend checks, running them again once they expired. The returned map must not be modified.
+func (c *readyCache) get(ctx context.Context) map[string]healthCheck {
+	c.mu.Lock()
+	checks, running := c.checks, c.running
+	switch {
+	case time.Now().Before(c.expires):
+		c.mu.Unlock()
+		return checks
+	case running != nil:
+		c.mu.Unlock()
+		if checks != nil {
+			return checks
+		}
+		<-running
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.checks
+	}
+	running = make(chan struct{})
+	c.running = running
+	c.mu.Unlock()
+
+	// the check is shared with the probes waiting for it, it isn't cut short by the one that started it
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), readyTimeout)
+	checks = backendChecks(ctx, c.client)
+	cancel()
+
+	c.mu.Lock()
+	c.checks, c.expires, c.running = checks, time.Now().Add(readyCacheTTL), nil
+	c.mu.Unlock()
+	close(running)
+	return checks
+}
+
+// backendChecks returns the chain_info check and, with the grpc client, the backends check of /readyz.
+func backendChecks(ctx context.Context, client Client) map[string]healthCheck {
+	checks := map[string]healthCheck{}
+	if c, ok := client.(*grpc.Client); ok {
+		// the chain infos are loaded by the client, we don't query the backends for them
+		chains := c.KnownChains()
+		hashes := make([]string, len(chains))
+		for i, info := range chains {
+			hashes[i] = info.Hash.String()
+		}
+		if len(chains) > 0 {
+			checks["chain_info"] = healthCheck{OK: true, Detail: hashes}
+		} else {
+			checks["chain_info"] = healthCheck{Error: "no chain info loaded"}
+		}
+
+		backends := c.Readiness(ctx, *readyMaxLag)
+		check := healthCheck{Detail: backends}
+		for _, b := range backends {
+			check.OK = check.OK || b.Ready
+		}
+		if !check.OK {
+			check.Error = fmt.Sprintf("no backend connected and at most %d rounds behind", *readyMaxLag)
+		}
+		checks["backends"] = check
+		return checks
+	}
+
+	chains, err := client.GetChains(ctx)
+	switch {
+	case err != nil:
+		checks["chain_info"] = healthCheck{Error: err.Error()}
+	case len(chains) == 0:
+		checks["chain_info"] = healthCheck{Error: "no chain info loaded"}
+	default:
+		checks["chain_info"] = healthCheck{OK: true, Detail: chains}
+	}
+	return checks
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/drand/drand/v2/common"
+	drandcrypto "github.com/drand/drand/v2/crypto"
+	"github.com/drand/http-relay/local"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	dev, err := local.NewDevChain(drandcrypto.SigsOnG1ID, time.Second, common.DefaultBeaconID)
+	require.NoError(t, err)
+	defer dev.Close()
+	h := drandHandler(context.Background(), dev)
+
+	get := func(path string) (int, map[string]any) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		var report map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		return w.Code, report
+	}
+
+	code, report := get("/livez")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "ok", report["status"])
+	require.Contains(t, report["checks"], "process")
+
+	code, report = get("/readyz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "ok", report["status"])
+	checks := report["checks"].(map[string]any)
+	require.Equal(t, true, checks["chain_info"].(map[string]any)["ok"])
+	require.Equal(t, true, checks["shutdown"].(map[string]any)["ok"])
+
+	// we are not ready anymore once shutting down, but still alive
+	shuttingDown.Store(true)
+	t.Cleanup(func() { shuttingDown.Store(false) })
+	code, report = get("/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, "fail", report["status"])
+	checks = report["checks"].(map[string]any)
+	require.Equal(t, false, checks["shutdown"].(map[string]any)["ok"])
+	require.Equal(t, "relay shutting down", checks["shutdown"].(map[string]any)["error"])
+	require.Equal(t, true, checks["chain_info"].(map[string]any)["ok"])
+
+	code, _ = get("/livez")
+	require.Equal(t, http.StatusOK, code)
+}
+
+// countingClient counts the GetChains calls of /readyz, which wait for gate to be closed when it is set.
+type countingClient struct {
+	Client
+	calls atomic.Int64
+	gate  chan struct{}
+}
+
+func (c *countingClient) GetChains(ctx context.Context) ([]string, error) {
+	c.calls.Add(1)
+	if c.gate != nil {
+		<-c.gate
+	}
+	return c.Client.GetChains(ctx)
+}
+
+func TestReadyz_Cached(t *testing.T) {
+	dev, err := local.NewDevChain(drandcrypto.SigsOnG1ID, time.Second, common.DefaultBeaconID)
+	require.NoError(t, err)
+	defer dev.Close()
+	client := &countingClient{Client: dev}
+	readyz := Readyz(client)
+
+	get := func() int {
+		w := httptest.NewRecorder()
+		readyz.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return w.Code
+	}
+
+	// probes don't query the backends each time
+	for range 10 {
+		require.Equal(t, http.StatusOK, get())
+	}
+	require.Equal(t, int64(1), client.calls.Load())
+
+	// while shutting down is reported right away
+	shuttingDown.Store(true)
+	t.Cleanup(func() { shuttingDown.Store(false) })
+	require.Equal(t, http.StatusServiceUnavailable, get())
+	require.Equal(t, int64(1), client.calls.Load())
+
+	require.Eventually(t, func() bool {
+		get()
+		return client.calls.Load() == 2
+	}, 3*readyCacheTTL, 10*time.Millisecond)
+}
+
+func TestReadyz_SlowCheck(t *testing.T) {
+	dev, err := local.NewDevChain(drandcrypto.SigsOnG1ID, time.Second, common.DefaultBeaconID)
+	require.NoError(t, err)
+	defer dev.Close()
+	client := &countingClient{Client: dev, gate: make(chan struct{})}
+	readyz := Readyz(client)
+
+	get := func() int {
+		w := httptest.NewRecorder()
+		readyz.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return w.Code
+	}
+
+	// the first probes wait for the first check
+	codes := make(chan int, 2)
+	for range 2 {
+		go func() { codes <- get() }()
+	}
+	require.Eventually(t, func() bool { return client.calls.Load() == 1 }, time.Second, time.Millisecond)
+	close(client.gate)
+	require.Equal(t, http.StatusOK, <-codes)
+	require.Equal(t, http.StatusOK, <-codes)
+	require.Equal(t, int64(1), client.calls.Load())
+
+	// once it expired, the probes get the previous check while a slow one runs
+	client.gate = make(chan struct{})
+	time.Sleep(readyCacheTTL)
+	go func() { codes <- get() }()
+	require.Eventually(t, func() bool { return client.calls.Load() == 2 }, time.Second, time.Millisecond)
+	require.Equal(t, http.StatusOK, get())
+	require.Equal(t, int64(2), client.calls.Load())
+	close(client.gate)
+	require.Equal(t, http.StatusOK, <-codes)
+}
@@ -0,0 +1,231 @@
+// Package jwks implements the subset of JSON Web Key Sets (RFC 7517) needed to verify the RS256, ES256 and EdDSA JWTs
+// accepted by the relay, loading them from a local file or from a URL and reloading them periodically.
+package jwks
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The JWT signing algorithms we support for asymmetric keys.
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// ErrUnknownKey is returned when a JWT refers to a key ID that is not part of the key set.
+var ErrUnknownKey = errors.New("unknown key ID")
+
+// Key is a JSON Web Key, only holding the fields of the public keys we support.
+type Key struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// Set is a JSON Web Key Set.
+type Set struct {
+	Keys []Key `json:"keys"`
+}
+
+var b64 = base64.RawURLEncoding
+
+// NewKey returns the JSON Web Key for an RSA, P-256 ECDSA or Ed25519 public key, with the algorithm it is used with.
+func NewKey(kid string, pub crypto.PublicKey) (Key, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return Key{Kty: "RSA", Kid: kid, Use: "sig", Alg: RS256, N: b64.EncodeToString(pub.N.Bytes()), E: b64.EncodeToString(big.NewInt(int64(pub.E)).Bytes())}, nil
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return Key{}, fmt.Errorf("unsupported curve %s, only P-256 is supported", pub.Curve.Params().Name)
+		}
+		// coordinates are always encoded using the full size of the field elements
+		x, y := make([]byte, 32), make([]byte, 32)
+		pub.X.FillBytes(x)
+		pub.Y.FillBytes(y)
+		return Key{Kty: "EC", Kid: kid, Use: "sig", Alg: ES256, Crv: "P-256", X: b64.EncodeToString(x), Y: b64.EncodeToString(y)}, nil
+	case ed25519.PublicKey:
+		return Key{Kty: "OKP", Kid: kid, Use: "sig", Alg: EdDSA, Crv: "Ed25519", X: b64.EncodeToString(pub)}, nil
+	default:
+		return Key{}, fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+// PublicKey decodes the JSON Web Key, returning its public key and the algorithm it must be used with.
+func (k *Key) PublicKey() (crypto.PublicKey, string, error) {
+	var pub crypto.PublicKey
+	var alg string
+	switch {
+	case k.Kty == "RSA":
+		n, errN := b64.DecodeString(k.N)
+		e, errE := b64.DecodeString(k.E)
+		if err := errors.Join(errN, errE); err != nil {
+			return nil, "", fmt.Errorf("invalid RSA key %q: %w", k.Kid, err)
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+			return nil, "", fmt.Errorf("invalid RSA key %q: invalid exponent", k.Kid)
+		}
+		rsaPub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}
+		if rsaPub.N.BitLen() < 2048 {
+			return nil, "", fmt.Errorf("invalid RSA key %q: keys must be at least 2048 bits long", k.Kid)
+		}
+		pub, alg = rsaPub, RS256
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, errX := b64.DecodeString(k.X)
+		y, errY := b64.DecodeString(k.Y)
+		if err := errors.Join(errX, errY); err != nil {
+			return nil, "", fmt.Errorf("invalid EC key %q: %w", k.Kid, err)
+		}
+		ecPub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !ecPub.Curve.IsOnCurve(ecPub.X, ecPub.Y) {
+			return nil, "", fmt.Errorf("invalid EC key %q: point is not on the P-256 curve", k.Kid)
+		}
+		pub, alg = ecPub, ES256
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		x, err := b64.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, "", fmt.Errorf("invalid Ed25519 key %q", k.Kid)
+		}
+		pub, alg = ed25519.PublicKey(x), EdDSA
+	default:
+		return nil, "", fmt.Errorf("unsupported key %q of type %q and curve %q", k.Kid, k.Kty, k.Crv)
+	}
+
+	if k.Alg != "" && k.Alg != alg {
+		return nil, "", fmt.Errorf("key %q of type %s cannot be used with algorithm %s", k.Kid, k.Kty, k.Alg)
+	}
+	return pub, alg, nil
+}
+
+type entry struct {
+	pub crypto.PublicKey
+	alg string
+}
+
+// Store holds the keys of a JSON Web Key Set loaded from a file or a URL, indexed by key ID.
+type Store struct {
+	source string
+
+	mu   sync.RWMutex
+	keys map[string]entry
+}
+
+// maxSetSize is the maximum size of a key set we are willing to read.
+const maxSetSize = 1 << 20
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// NewStore loads the key set from the provided source, which is either a local file or an http(s) URL.
+func NewStore(source string) (*Store, error) {
+	s := &Store{source: source}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reloads the key set from its source, keeping the current keys if it fails.
+func (s *Store) Reload() error {
+	data, err := s.read()
+	if err != nil {
+		return fmt.Errorf("unable to read key set from %s: %w", s.source, err)
+	}
+
+	var set Set
+	if err := json.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("invalid key set from %s: %w", s.source, err)
+	}
+
+	keys := make(map[string]entry, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if k.Kid == "" {
+			return fmt.Errorf("invalid key set from %s: all keys must have a key ID", s.source)
+		}
+		if _, ok := keys[k.Kid]; ok {
+			return fmt.Errorf("invalid key set from %s: duplicate key ID %q", s.source, k.Kid)
+		}
+		pub, alg, err := k.PublicKey()
+		if err != nil {
+			return fmt.Errorf("invalid key set from %s: %w", s.source, err)
+		}
+		keys[k.Kid] = entry{pub: pub, alg: alg}
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Store) read() ([]byte, error) {
+	if !strings.HasPrefix(s.source, "http://") && !strings.HasPrefix(s.source, "https://") {
+		return os.ReadFile(s.source)
+	}
+
+	resp, err := httpClient.Get(s.source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxSetSize))
+}
+
+// Watch reloads the key set every interval until the context is cancelled, logging failures.
+func (s *Store) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Reload(); err != nil {
+				slog.Error("unable to reload JWKS, keeping the current keys", "err", err)
+			}
+		}
+	}
+}
+
+// Key returns the public key with the provided key ID, making sure it is meant to be used with the algorithm alg.
+func (s *Store) Key(kid, alg string) (crypto.PublicKey, error) {
+	s.mu.RLock()
+	e, ok := s.keys[kid]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+	if e.alg != alg {
+		return nil, fmt.Errorf("key %q cannot be used with algorithm %s", kid, alg)
+	}
+	return e.pub, nil
+}
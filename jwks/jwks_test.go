@@ -0,0 +1,123 @@
+package jwks
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testKeys(t *testing.T) map[string]crypto.PublicKey {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return map[string]crypto.PublicKey{RS256: &rsaKey.PublicKey, ES256: &ecKey.PublicKey, EdDSA: edPub}
+}
+
+func marshalSet(t *testing.T, keys map[string]crypto.PublicKey) []byte {
+	t.Helper()
+	var set Set
+	for kid, pub := range keys {
+		k, err := NewKey(kid, pub)
+		require.NoError(t, err)
+		set.Keys = append(set.Keys, k)
+	}
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+	return data
+}
+
+func TestKeyRoundTrip(t *testing.T) {
+	for alg, pub := range testKeys(t) {
+		k, err := NewKey("kid", pub)
+		require.NoError(t, err)
+		got, gotAlg, err := k.PublicKey()
+		require.NoError(t, err)
+		require.Equal(t, alg, gotAlg)
+		require.True(t, got.(interface{ Equal(crypto.PublicKey) bool }).Equal(pub), alg)
+	}
+
+	// a key cannot be used with another algorithm than the one of its type
+	k, err := NewKey("kid", testKeys(t)[EdDSA])
+	require.NoError(t, err)
+	k.Alg = RS256
+	_, _, err = k.PublicKey()
+	require.Error(t, err)
+
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	_, err = NewKey("kid", &p384.PublicKey)
+	require.Error(t, err)
+}
+
+func TestStoreFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	keys := testKeys(t)
+	require.NoError(t, os.WriteFile(path, marshalSet(t, keys), 0o600))
+
+	s, err := NewStore(path)
+	require.NoError(t, err)
+	for alg, pub := range keys {
+		got, err := s.Key(alg, alg)
+		require.NoError(t, err)
+		require.Equal(t, pub, got)
+	}
+	_, err = s.Key(RS256, ES256)
+	require.Error(t, err)
+	_, err = s.Key("unknown", RS256)
+	require.ErrorIs(t, err, ErrUnknownKey)
+
+	// an invalid key set is refused, keeping the current keys
+	require.NoError(t, os.WriteFile(path, []byte(`{"keys":[{"kty":"RSA","kid":"bad"}]}`), 0o600))
+	require.Error(t, s.Reload())
+	_, err = s.Key(EdDSA, EdDSA)
+	require.NoError(t, err)
+}
+
+func TestStoreFromURL(t *testing.T) {
+	first := testKeys(t)
+	second := map[string]crypto.PublicKey{"rotated": first[EdDSA]}
+	var rotated atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/jwks.json" {
+			http.NotFound(w, r)
+			return
+		}
+		if rotated.Load() {
+			w.Write(marshalSet(t, second))
+			return
+		}
+		w.Write(marshalSet(t, first))
+	}))
+	defer srv.Close()
+
+	s, err := NewStore(srv.URL + "/jwks.json")
+	require.NoError(t, err)
+	_, err = s.Key(ES256, ES256)
+	require.NoError(t, err)
+
+	rotated.Store(true)
+	require.NoError(t, s.Reload())
+	_, err = s.Key(ES256, ES256)
+	require.ErrorIs(t, err, ErrUnknownKey)
+	_, err = s.Key("rotated", EdDSA)
+	require.NoError(t, err)
+
+	_, err = NewStore(srv.URL + "/missing")
+	require.Error(t, err)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/drand/http-relay/apikeys"
+)
+
+func apikeyCmd(args []string) error {
+	fs := flag.NewFlagSet("apikey", flag.ExitOnError)
+	keysFile := fs.String("keys", "", "The API keys file of the relays to add the key to, it is created if it doesn't exist.")
+	owner := fs.String("owner", "", "The owner of the key, used as subject for scopes, rate limits and usage accounting.")
+	expiry := fs.Duration("expiry", 0, "How long the key is valid for, 0 means that it never expires.")
+	chains := &listFlag{}
+	fs.Var(chains, "chains", "Comma-separated list of the chain hashes the key can access, all of them if not set.")
+	beaconIDs := &listFlag{}
+	fs.Var(beaconIDs, "beacon-ids", "Comma-separated list of the beacon IDs the key can access, all of them if not set.")
+	ops := &listFlag{}
+	fs.Var(ops, "ops", "Comma-separated list of the operations the key can use among latest, next and rounds, all of them if not set.")
+	tier := fs.String("tier", "", "The rate limiting tier of the key, as set in the relay --rate-limits file.")
+	revoke := fs.String("revoke", "", "The ID of a key to remove from the --keys file instead of adding one.")
+	fs.Parse(args)
+
+	if *keysFile == "" {
+		return errors.New("--keys is required")
+	}
+
+	keys, err := apikeys.Load(*keysFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if *revoke != "" {
+		n := len(keys)
+		keys = slices.DeleteFunc(keys, func(k apikeys.Key) bool { return k.ID == *revoke })
+		if len(keys) == n {
+			return fmt.Errorf("key ID %q not found in %s", *revoke, *keysFile)
+		}
+		if err := writeKeys(*keysFile, keys); err != nil {
+			return err
+		}
+		return printJSON(map[string]string{"revoked": *revoke})
+	}
+
+	if *owner == "" {
+		return errors.New("--owner is required")
+	}
+	apiKey, k, err := apikeys.New(*owner)
+	if err != nil {
+		return fmt.Errorf("unable to generate API key: %w", err)
+	}
+	if *expiry > 0 {
+		exp := time.Now().Add(*expiry).UTC().Truncate(time.Second)
+		k.ExpiresAt = &exp
+	}
+	if chains.list != nil {
+		k.Chains = *chains.list
+	}
+	if beaconIDs.list != nil {
+		k.BeaconIDs = *beaconIDs.list
+	}
+	if ops.list != nil {
+		k.Ops = *ops.list
+	}
+	k.Tier = *tier
+
+	if err := writeKeys(*keysFile, append(keys, k)); err != nil {
+		return err
+	}
+
+	// the key itself is never stored, it is only displayed once
+	response := map[string]string{"api_key": apiKey, "id": k.ID, "owner": k.Owner}
+	if k.ExpiresAt != nil {
+		response["expires_at"] = k.ExpiresAt.Format(time.RFC3339)
+	}
+	return printJSON(response)
+}
+
+func writeKeys(path string, keys []apikeys.Key) error {
+	if keys == nil {
+		keys = []apikeys.Key{}
+	}
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("unable to write API keys file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/drand/http-relay/apikeys"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApikey(t *testing.T) {
+	keysFile := filepath.Join(t.TempDir(), "keys.json")
+
+	for _, args := range [][]string{
+		{"--owner", "alice"},
+		{"--keys", keysFile},
+		{"--keys", keysFile, "--revoke", "unknown"},
+	} {
+		_, err := run(t, apikeyCmd, args...)
+		require.Error(t, err, args)
+	}
+
+	added := runJSON(t, apikeyCmd, "--keys", keysFile, "--owner", "alice", "--ops", "latest", "--tier", "gold", "--expiry", "1h")
+	require.Equal(t, "alice", added["owner"])
+	require.NotEmpty(t, added["expires_at"])
+	other := runJSON(t, apikeyCmd, "--keys", keysFile, "--owner", "bob")
+
+	// the keys are verified by the relays using the keys file
+	store, err := apikeys.NewStore(keysFile)
+	require.NoError(t, err)
+	require.Equal(t, 2, store.Len())
+	k, err := store.Verify(added["api_key"].(string), time.Now())
+	require.NoError(t, err)
+	require.Equal(t, "alice", k.Owner)
+	require.Equal(t, []string{"latest"}, k.Ops)
+	require.Equal(t, "gold", k.Tier)
+	require.Nil(t, k.Chains)
+	_, err = store.Verify(added["api_key"].(string), time.Now().Add(2*time.Hour))
+	require.Error(t, err)
+
+	revoked := runJSON(t, apikeyCmd, "--keys", keysFile, "--revoke", added["id"].(string))
+	require.Equal(t, added["id"], revoked["revoked"])
+	keys, err := apikeys.Load(keysFile)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.Equal(t, other["id"], keys[0].ID)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/drand/http-relay/jwks"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func keygenCmd(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	alg := fs.String("alg", "HS256", "The algorithm of the key to generate, one of HS256, RS256, ES256 or EdDSA.")
+	kid := fs.String("kid", "", "The ID of the generated asymmetric key in the JWKS, a random one by default.")
+	out := fs.String("out", "", "The file to write the PEM private key of the generated asymmetric key to.")
+	jwksFile := fs.String("jwks", "", "The JWKS file to add the public key of the generated asymmetric key to, it is created if it doesn't exist.")
+	fs.Parse(args)
+
+	if *alg == "HS256" {
+		secret := make([]byte, 128)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		// the secret is meant to be set as the DRAND_AUTH_KEY env variable of the relays and the issuer
+		fmt.Println(hex.EncodeToString(secret))
+		return nil
+	}
+
+	if *out == "" || *jwksFile == "" {
+		return errors.New("both --out and --jwks files are required for asymmetric keys")
+	}
+
+	var signer crypto.Signer
+	var err error
+	switch *alg {
+	case jwks.RS256:
+		signer, err = rsa.GenerateKey(rand.Reader, 3072)
+	case jwks.ES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwks.EdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return fmt.Errorf("unsupported --alg %q", *alg)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to generate key: %w", err)
+	}
+
+	if *kid == "" {
+		if *kid, err = randomHex(8); err != nil {
+			return err
+		}
+	}
+
+	// we add the key to the existing key set, so that keys can be rotated while the older ones are still valid
+	var set jwks.Set
+	data, err := os.ReadFile(*jwksFile)
+	if err == nil {
+		if err := json.Unmarshal(data, &set); err != nil {
+			return fmt.Errorf("invalid JWKS file %s: %w", *jwksFile, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if slices.ContainsFunc(set.Keys, func(k jwks.Key) bool { return k.Kid == *kid }) {
+		return fmt.Errorf("key ID %q already exists in %s", *kid, *jwksFile)
+	}
+	key, err := jwks.NewKey(*kid, signer.Public())
+	if err != nil {
+		return err
+	}
+	set.Keys = append(set.Keys, key)
+
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return err
+	}
+	// we never overwrite an existing private key
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("unable to create private key file: %w", err)
+	}
+	if err := errors.Join(pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der}), f.Close()); err != nil {
+		return fmt.Errorf("unable to write private key file: %w", err)
+	}
+
+	data, err = json.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*jwksFile, data, 0o644); err != nil {
+		return fmt.Errorf("unable to write JWKS file: %w", err)
+	}
+
+	return printJSON(map[string]string{"kid": *kid, "alg": *alg, "private_key": *out, "jwks": *jwksFile})
+}
+
+// loadPrivateKey reads a PEM encoded PKCS8 private key, as written by keygen.
+func loadPrivateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("no PEM private key found in %s", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key in %s: %w", path, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// signingMethod returns the JWT signing method matching the provided public key, as listed in its JWK.
+func signingMethod(pub crypto.PublicKey) (jwt.SigningMethod, error) {
+	key, err := jwks.NewKey("", pub)
+	if err != nil {
+		return nil, err
+	}
+	method := jwt.GetSigningMethod(key.Alg)
+	if method == nil {
+		return nil, fmt.Errorf("unsupported algorithm %s", key.Alg)
+	}
+	return method, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/drand/http-relay/jwks"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeygen(t *testing.T) {
+	out, err := run(t, keygenCmd)
+	require.NoError(t, err)
+	secret, err := hex.DecodeString(strings.TrimSpace(out))
+	require.NoError(t, err)
+	require.Len(t, secret, 128)
+
+	dir := t.TempDir()
+	jwksFile := filepath.Join(dir, "jwks.json")
+	for _, alg := range []string{jwks.RS256, jwks.ES256, jwks.EdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			keyFile := filepath.Join(dir, alg+".pem")
+			res := runJSON(t, keygenCmd, "--alg", alg, "--kid", alg, "--out", keyFile, "--jwks", jwksFile)
+			require.Equal(t, alg, res["kid"])
+
+			// the keys are added to the key set loaded by the relays
+			keys, err := jwks.NewStore(jwksFile)
+			require.NoError(t, err)
+			pub, err := keys.Key(alg, alg)
+			require.NoError(t, err)
+
+			signer, err := loadPrivateKey(keyFile)
+			require.NoError(t, err)
+			require.Equal(t, signer.Public(), pub)
+			method, err := signingMethod(pub)
+			require.NoError(t, err)
+			require.Equal(t, alg, method.Alg())
+		})
+	}
+
+	keys, err := jwks.NewStore(jwksFile)
+	require.NoError(t, err)
+	for _, alg := range []string{jwks.RS256, jwks.ES256, jwks.EdDSA} {
+		_, err := keys.Key(alg, alg)
+		require.NoError(t, err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		args []string
+	}{
+		{name: "existing kid", args: []string{"--alg", jwks.EdDSA, "--kid", jwks.EdDSA, "--out", filepath.Join(dir, "new.pem"), "--jwks", jwksFile}},
+		{name: "existing private key", args: []string{"--alg", jwks.EdDSA, "--out", filepath.Join(dir, jwks.EdDSA+".pem"), "--jwks", jwksFile}},
+		{name: "missing files", args: []string{"--alg", jwks.EdDSA}},
+		{name: "unsupported alg", args: []string{"--alg", "HS512", "--out", filepath.Join(dir, "hs.pem"), "--jwks", jwksFile}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := run(t, keygenCmd, tc.args...)
+			require.Error(t, err)
+		})
+	}
+}
@@ -1,65 +1,321 @@
 package main
 
 import (
+	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"log/slog"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/drand/http-relay/jwks"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var (
-	version   = "v0.0.1"
-	goVersion = flag.Bool("version", false, "Displays the current server version.")
-	jwtSecret []byte
-)
+var version = "v0.0.1"
+
+const usage = `drand http JWT issuer %s
+
+Usage:
+  jwtissuer keygen [flags]           generates an HMAC secret, or an asymmetric keypair and its JWKS
+  jwtissuer issue [flags] [secret]   issues a token with the provided claims
+  jwtissuer inspect <token>          decodes a token without verifying it
+  jwtissuer verify [flags] <token>   verifies a token against an HMAC secret or a JWKS
+  jwtissuer apikey [flags]           adds an API key to, or revokes one from, the relays API keys file
+  jwtissuer presign [flags] <url>    presigns a relay URL using the HMAC secret
+  jwtissuer version                  displays the issuer version
 
-func init() {
-	flag.Parse()
+HMAC secrets are 128 bytes hex-encoded, read from the DRAND_AUTH_KEY env variable or from the arguments.
+Run "jwtissuer <command> -h" for the flags of each command.
+`
+
+func main() {
+	if len(os.Args) > 1 && (os.Args[1] == "-h" || os.Args[1] == "--help" || os.Args[1] == "help") {
+		fmt.Fprintf(os.Stderr, usage, version)
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd := firstArg(); cmd {
+	case "keygen":
+		err = keygenCmd(os.Args[2:])
+	case "issue":
+		err = issueCmd(os.Args[2:])
+	case "inspect":
+		err = inspectCmd(os.Args[2:])
+	case "verify":
+		err = verifyCmd(os.Args[2:])
+	case "apikey":
+		err = apikeyCmd(os.Args[2:])
+	case "presign":
+		err = presignCmd(os.Args[2:])
+	case "version", "--version", "-version":
+		fmt.Println("drand http JWT issuer version:", version)
+	default:
+		// we used to only issue claimless tokens using the secret provided as argument
+		err = issueCmd(os.Args[1:])
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func firstArg() string {
+	if len(os.Args) < 2 {
+		return ""
+	}
+	return os.Args[1]
+}
+
+// readSecret returns the HMAC secret from the DRAND_AUTH_KEY env variable, or from the provided argument.
+func readSecret(arg string) ([]byte, error) {
 	secret, provided := os.LookupEnv("DRAND_AUTH_KEY")
 	if !provided {
-		secret = flag.Arg(0)
-	} else {
+		secret = arg
+	} else if arg != "" {
 		slog.Error("Using DRAND_AUTH_KEY var env, ignoring binary arguments")
 	}
 	if len(secret) < 256 {
-		log.Fatal("DRAND_AUTH_KEY not provided as a 128 byte hex-encoded secret in argument. Got ", len(secret), " char: ", secret)
-	} else {
-		var err error
-		jwtSecret, err = hex.DecodeString(secret)
-		if err != nil {
-			log.Fatal("unable to parse AUTH_TOKEN as valid hex")
+		return nil, fmt.Errorf("DRAND_AUTH_KEY not provided as a 128 byte hex-encoded secret, got %d chars", len(secret))
+	}
+	jwtSecret, err := hex.DecodeString(secret)
+	if err != nil {
+		return nil, errors.New("unable to parse DRAND_AUTH_KEY as valid hex")
+	}
+	return jwtSecret, nil
+}
+
+// claims are the claims of the tokens we issue, see the relay scopeClaims. The scopes are pointers to distinguish
+// the missing ones, which don't restrict anything, from the empty ones, which grant nothing.
+type claims struct {
+	jwt.RegisteredClaims
+	Chains    *[]string `json:"chains,omitempty"`
+	BeaconIDs *[]string `json:"beacon_ids,omitempty"`
+	Ops       *[]string `json:"ops,omitempty"`
+	Tier      string    `json:"tier,omitempty"`
+}
+
+// listFlag is a comma-separated list flag, which is nil unless it was set.
+type listFlag struct {
+	list *[]string
+}
+
+func (l *listFlag) String() string {
+	if l.list == nil {
+		return ""
+	}
+	return strings.Join(*l.list, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	list := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
 		}
 	}
+	l.list = &list
+	return nil
 }
 
-func main() {
-	if *goVersion {
-		log.Fatal("drand http JWT issuer version: ", version)
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
+	return hex.EncodeToString(b), nil
+}
 
-	// Create a new token object
-	token := jwt.New(jwt.SigningMethodHS256)
+func issueCmd(args []string) error {
+	fs := flag.NewFlagSet("issue", flag.ExitOnError)
+	expiry := fs.Duration("expiry", 0, "How long the token is valid for, 0 means that it never expires.")
+	issuer := fs.String("issuer", "", "The iss claim of the token, checked by relays run with --auth-issuer.")
+	audience := &listFlag{}
+	fs.Var(audience, "audience", "Comma-separated aud claim of the token, checked by relays run with --auth-audience.")
+	subject := fs.String("subject", "", "The sub claim of the token, identifying who it was issued to.")
+	chains := &listFlag{}
+	fs.Var(chains, "chains", "Comma-separated list of the chain hashes the token can access, all of them if not set.")
+	beaconIDs := &listFlag{}
+	fs.Var(beaconIDs, "beacon-ids", "Comma-separated list of the beacon IDs the token can access, all of them if not set.")
+	ops := &listFlag{}
+	fs.Var(ops, "ops", "Comma-separated list of the operations the token can use among latest, next and rounds, all of them if not set.")
+	tier := fs.String("tier", "", "The rate limiting tier of the token, as set in the relay --rate-limits file.")
+	keyFile := fs.String("key", "", "The PEM private key file signing the token using RS256, ES256 or EdDSA instead of the HMAC secret.")
+	kid := fs.String("kid", "", "The ID of the --key in the JWKS of the relays, required with --key.")
+	fs.Parse(args)
 
-	// Create a JWT and send it as response
-	tokenString, err := token.SignedString(jwtSecret)
+	// every token gets a random ID, allowing to revoke it using the relay --auth-revoked list
+	jti, err := randomHex(16)
 	if err != nil {
-		log.Fatal("Error while signing the token")
+		return fmt.Errorf("unable to generate token ID: %w", err)
+	}
+	now := time.Now()
+	c := claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       jti,
+			IssuedAt: jwt.NewNumericDate(now),
+			Issuer:   *issuer,
+			Subject:  *subject,
+		},
+		Chains:    chains.list,
+		BeaconIDs: beaconIDs.list,
+		Ops:       ops.list,
+		Tier:      *tier,
+	}
+	if audience.list != nil {
+		c.Audience = *audience.list
+	}
+	if *expiry > 0 {
+		c.ExpiresAt = jwt.NewNumericDate(now.Add(*expiry))
+	}
+
+	var tokenString string
+	if *keyFile != "" {
+		if *kid == "" {
+			return errors.New("--kid is required to sign using --key")
+		}
+		signer, err := loadPrivateKey(*keyFile)
+		if err != nil {
+			return err
+		}
+		method, err := signingMethod(signer.Public())
+		if err != nil {
+			return err
+		}
+		token := jwt.NewWithClaims(method, c)
+		token.Header["kid"] = *kid
+		tokenString, err = token.SignedString(signer)
+		if err != nil {
+			return fmt.Errorf("error while signing the token: %w", err)
+		}
+	} else {
+		secret, err := readSecret(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		tokenString, err = jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString(secret)
+		if err != nil {
+			return fmt.Errorf("error while signing the token: %w", err)
+		}
 	}
 
 	response := map[string]string{
 		"token": tokenString,
+		"jti":   c.ID,
+	}
+	if c.ExpiresAt != nil {
+		response["expires_at"] = c.ExpiresAt.Format(time.RFC3339)
 	}
 
 	log.Println("Created a valid JWT", "token", tokenString)
 	res, err := json.Marshal(response)
 	if err != nil {
-		log.Fatal("Unable to marshal JWT token:", err)
+		return fmt.Errorf("unable to marshal JWT token: %w", err)
 	}
 
 	fmt.Println(string(res))
+	return nil
+}
+
+func inspectCmd(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("expected a single token to inspect")
+	}
+
+	c := jwt.MapClaims{}
+	token, _, err := jwt.NewParser().ParseUnverified(fs.Arg(0), c)
+	if err != nil {
+		return fmt.Errorf("unable to decode token: %w", err)
+	}
+
+	report := map[string]any{
+		"header": token.Header,
+		"claims": c,
+	}
+	// we also display the time claims in a human readable way
+	for name, get := range map[string]func() (*jwt.NumericDate, error){
+		"expires_at": c.GetExpirationTime,
+		"not_before": c.GetNotBefore,
+		"issued_at":  c.GetIssuedAt,
+	} {
+		if t, err := get(); err == nil && t != nil {
+			report[name] = t.Format(time.RFC3339)
+		}
+	}
+	if exp, err := c.GetExpirationTime(); err == nil && exp != nil {
+		report["expired"] = exp.Before(time.Now())
+	}
+
+	return printJSON(report)
+}
+
+func verifyCmd(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	jwksSource := fs.String("jwks", "", "A local file or http(s) URL serving the JWKS to verify asymmetric tokens against, instead of the HMAC secret.")
+	issuer := fs.String("issuer", "", "When set, the token must have this iss claim.")
+	audience := fs.String("audience", "", "When set, the token must include this value in its aud claim.")
+	requireExp := fs.Bool("require-exp", false, "Refuses tokens without an exp claim.")
+	leeway := fs.Duration("leeway", 30*time.Second, "The clock skew tolerated when validating the exp, nbf and iat claims.")
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return errors.New("expected the token to verify, optionally followed by the HMAC secret")
+	}
+
+	opts := []jwt.ParserOption{jwt.WithLeeway(*leeway), jwt.WithIssuedAt()}
+	if *issuer != "" {
+		opts = append(opts, jwt.WithIssuer(*issuer))
+	}
+	if *audience != "" {
+		opts = append(opts, jwt.WithAudience(*audience))
+	}
+	if *requireExp {
+		opts = append(opts, jwt.WithExpirationRequired())
+	}
+
+	var keyFunc jwt.Keyfunc
+	if *jwksSource != "" {
+		keys, err := jwks.NewStore(*jwksSource)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, jwt.WithValidMethods([]string{jwks.RS256, jwks.ES256, jwks.EdDSA}))
+		keyFunc = func(token *jwt.Token) (interface{}, error) {
+			kid, ok := token.Header["kid"].(string)
+			if !ok {
+				return nil, errors.New("missing kid header")
+			}
+			return keys.Key(kid, token.Method.Alg())
+		}
+	} else {
+		secret, err := readSecret(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		opts = append(opts, jwt.WithValidMethods([]string{"HS256", "HS384"}))
+		keyFunc = func(*jwt.Token) (interface{}, error) {
+			return secret, nil
+		}
+	}
+
+	c := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(fs.Arg(0), c, keyFunc, opts...); err != nil {
+		return fmt.Errorf("invalid token: %w", err)
+	}
+
+	return printJSON(map[string]any{"valid": true, "claims": c})
+}
+
+func printJSON(v any) error {
+	// we don't escape HTML characters, so that URLs can be copied as they are
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
 }
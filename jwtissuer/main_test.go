@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testSecret is a valid hex-encoded HMAC secret.
+var testSecret = strings.Repeat("ab", 128)
+
+// run runs the command with the provided arguments, returning what it printed on stdout.
+func run(t *testing.T, cmd func([]string) error, args ...string) (string, error) {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	stdout := os.Stdout
+	os.Stdout = f
+	err = cmd(args)
+	os.Stdout = stdout
+
+	_, serr := f.Seek(0, io.SeekStart)
+	require.NoError(t, serr)
+	out, serr := io.ReadAll(f)
+	require.NoError(t, serr)
+	return string(out), err
+}
+
+// runJSON runs the command, which must succeed and print a JSON object.
+func runJSON(t *testing.T, cmd func([]string) error, args ...string) map[string]any {
+	t.Helper()
+	out, err := run(t, cmd, args...)
+	require.NoError(t, err)
+	res := make(map[string]any)
+	require.NoError(t, json.Unmarshal([]byte(out), &res), out)
+	return res
+}
+
+func TestReadSecret(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		env  string
+		arg  string
+		err  bool
+	}{
+		{name: "argument", arg: testSecret},
+		{name: "env", env: testSecret},
+		{name: "env wins", env: testSecret, arg: "ignored"},
+		{name: "missing", err: true},
+		{name: "too short", arg: "abcd", err: true},
+		{name: "not hex", arg: strings.Repeat("zz", 128), err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("DRAND_AUTH_KEY", tc.env)
+			if tc.env == "" {
+				os.Unsetenv("DRAND_AUTH_KEY")
+			}
+			secret, err := readSecret(tc.arg)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, secret, 128)
+		})
+	}
+}
+
+func TestIssueVerify(t *testing.T) {
+	t.Setenv("DRAND_AUTH_KEY", testSecret)
+	dir := t.TempDir()
+	runJSON(t, keygenCmd, "--alg", "EdDSA", "--kid", "k1", "--out", filepath.Join(dir, "k1.pem"), "--jwks", filepath.Join(dir, "jwks.json"))
+
+	for _, tc := range []struct {
+		name   string
+		issue  []string
+		verify []string
+		err    bool
+	}{
+		{name: "hmac", issue: []string{"--subject", "alice"}},
+		{name: "hmac issuer", issue: []string{"--issuer", "me", "--audience", "relays"}, verify: []string{"--issuer", "me", "--audience", "relays"}},
+		{name: "hmac wrong issuer", issue: []string{"--issuer", "me"}, verify: []string{"--issuer", "other"}, err: true},
+		{name: "hmac wrong audience", issue: []string{"--audience", "relays"}, verify: []string{"--audience", "other"}, err: true},
+		{name: "hmac missing expiry", verify: []string{"--require-exp"}, err: true},
+		{name: "hmac expiry", issue: []string{"--expiry", "1h"}, verify: []string{"--require-exp"}},
+		{name: "asymmetric", issue: []string{"--key", filepath.Join(dir, "k1.pem"), "--kid", "k1"}, verify: []string{"--jwks", filepath.Join(dir, "jwks.json")}},
+		{name: "asymmetric against hmac", issue: []string{"--key", filepath.Join(dir, "k1.pem"), "--kid", "k1"}, err: true},
+		{name: "hmac against jwks", verify: []string{"--jwks", filepath.Join(dir, "jwks.json")}, err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			issued := runJSON(t, issueCmd, tc.issue...)
+			token := issued["token"].(string)
+			require.NotEmpty(t, issued["jti"])
+
+			out, err := run(t, verifyCmd, append(tc.verify, token)...)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			var verified struct {
+				Valid  bool           `json:"valid"`
+				Claims map[string]any `json:"claims"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(out), &verified))
+			require.True(t, verified.Valid)
+			require.Equal(t, issued["jti"], verified.Claims["jti"])
+		})
+	}
+
+	// the scopes are only set when provided, an empty list granting nothing
+	issued := runJSON(t, issueCmd, "--subject", "alice", "--chains", "", "--ops", "latest,next", "--tier", "gold")
+	claims := runJSON(t, inspectCmd, issued["token"].(string))["claims"].(map[string]any)
+	require.Equal(t, "alice", claims["sub"])
+	require.Equal(t, []any{}, claims["chains"])
+	require.Equal(t, []any{"latest", "next"}, claims["ops"])
+	require.Equal(t, "gold", claims["tier"])
+	require.NotContains(t, claims, "beacon_ids")
+}
+
+func TestInspect(t *testing.T) {
+	t.Setenv("DRAND_AUTH_KEY", testSecret)
+	issued := runJSON(t, issueCmd, "--subject", "bob", "--expiry", "1h")
+
+	report := runJSON(t, inspectCmd, issued["token"].(string))
+	require.Equal(t, "HS256", report["header"].(map[string]any)["alg"])
+	require.Equal(t, "bob", report["claims"].(map[string]any)["sub"])
+	require.Equal(t, issued["expires_at"], report["expires_at"])
+	require.Equal(t, false, report["expired"])
+
+	_, err := run(t, inspectCmd, "not-a-token")
+	require.Error(t, err)
+	_, err = run(t, inspectCmd)
+	require.Error(t, err)
+}
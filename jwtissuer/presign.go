@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/drand/http-relay/presign"
+)
+
+func presignCmd(args []string) error {
+	fs := flag.NewFlagSet("presign", flag.ExitOnError)
+	expiry := fs.Duration("expiry", time.Hour, "How long the URL is valid for, relays refuse URLs valid for longer than their --auth-presigned-max.")
+	subject := fs.String("subject", "", "The subject of the URL, used by the relays for rate limiting and usage accounting.")
+	prefix := fs.String("prefix", "", "Signs all the paths under that prefix, e.g. /v2/beacons/quicknet, instead of only the URL path.")
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return errors.New("expected the URL to sign, optionally followed by the HMAC secret")
+	}
+	if *expiry <= 0 {
+		return errors.New("--expiry must be positive")
+	}
+
+	secret, err := readSecret(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	expires := now.Add(*expiry)
+	signed, err := presign.Sign(secret, fs.Arg(0), expires, presign.WithSubject(*subject), presign.WithPrefix(*prefix),
+		presign.WithIssuedAt(now))
+	if err != nil {
+		return fmt.Errorf("unable to sign URL: %w", err)
+	}
+	return printJSON(map[string]string{"url": signed, "expires_at": expires.UTC().Format(time.RFC3339)})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/drand/http-relay/presign"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresign(t *testing.T) {
+	t.Setenv("DRAND_AUTH_KEY", testSecret)
+	secret, err := hex.DecodeString(testSecret)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name  string
+		args  []string
+		check string
+		err   bool
+	}{
+		{name: "path", args: []string{"--subject", "alice", "https://relay/v2/beacons/quicknet/rounds/1"}, check: "https://relay/v2/beacons/quicknet/rounds/1"},
+		{name: "prefix", args: []string{"--prefix", "/v2/beacons/quicknet", "https://relay/v2/beacons/quicknet"}, check: "https://relay/v2/beacons/quicknet/rounds/2"},
+		{name: "missing URL", err: true},
+		{name: "negative expiry", args: []string{"--expiry", "-1h", "https://relay/v2/beacons"}, err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.err {
+				_, err := run(t, presignCmd, tc.args...)
+				require.Error(t, err)
+				return
+			}
+			res := runJSON(t, presignCmd, tc.args...)
+
+			signed, err := url.Parse(res["url"].(string))
+			require.NoError(t, err)
+			check, err := url.Parse(tc.check)
+			require.NoError(t, err)
+			check.RawQuery = signed.RawQuery
+
+			subject, expires, err := presign.Verify(secret, check, time.Now(), 2*time.Hour)
+			require.NoError(t, err)
+			require.Equal(t, signed.Query().Get(presign.SubjectParam), subject)
+			require.WithinDuration(t, time.Now().Add(time.Hour), expires, time.Minute)
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const (
+	unixPrefix = "unix:"
+	// systemdBind is the --bind address of the sockets passed by systemd socket activation.
+	systemdBind = "systemd"
+	// listenFdsStart is the first file descriptor passed by systemd, following stdin, stdout and stderr.
+	listenFdsStart = 3
+)
+
+// listen returns the listeners of the comma-separated --bind addresses, which are either TCP addresses, Unix sockets
+// paths prefixed by "unix:", or "systemd" for the sockets passed by systemd socket activation. The listeners inherited
+// from the relay we are upgrading, if any, are used instead.
+func listen(binds string) ([]net.Listener, error) {
+	listeners, err := inherited(bindListener)
+	if err != nil || len(listeners) > 0 {
+		return listeners, err
+	}
+
+	closeAll := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+
+	for _, bind := range strings.Split(binds, ",") {
+		bind = strings.TrimSpace(bind)
+		var ls []net.Listener
+		switch {
+		case bind == "":
+			continue
+		case bind == systemdBind:
+			ls, err = systemdListeners()
+		case strings.HasPrefix(bind, unixPrefix):
+			var l net.Listener
+			l, err = listenUnix(strings.TrimPrefix(bind, unixPrefix))
+			ls = []net.Listener{l}
+		default:
+			var l net.Listener
+			l, err = net.Listen("tcp", bind)
+			ls = []net.Listener{l}
+		}
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("unable to listen on %q: %w", bind, err)
+		}
+		listeners = append(listeners, ls...)
+	}
+
+	if len(listeners) == 0 {
+		return nil, errors.New("no address to listen on, please set --bind")
+	}
+	return listeners, nil
+}
+
+// listenUnix listens on the Unix socket at path, removing the stale socket left by a previous run, if any. A socket
+// still accepting connections is never removed, since another relay is using it.
+func listenUnix(path string) (net.Listener, error) {
+	if st, err := os.Stat(path); err == nil && st.Mode().Type() == fs.ModeSocket {
+		if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("socket %s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+type unixPeerCtxKey struct{}
+
+// markUnixPeers is the http.Server ConnContext marking the connections accepted on Unix sockets, see unixPeer.
+func markUnixPeers(ctx context.Context, c net.Conn) context.Context {
+	if c.LocalAddr().Network() == "unix" {
+		return context.WithValue(ctx, unixPeerCtxKey{}, true)
+	}
+	return ctx
+}
+
+// unixPeer returns whether the request was received on a Unix socket. Such peers have no IP, so they are not subject
+// to the limits and lockouts keyed on the client IP: their access is controlled using the socket permissions.
+func unixPeer(r *http.Request) bool {
+	unix, _ := r.Context().Value(unixPeerCtxKey{}).(bool)
+	return unix
+}
+
+// systemdListeners returns the sockets passed by systemd socket activation, following the sd_listen_fds protocol.
+func systemdListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("no socket passed by systemd, LISTEN_PID is not set to our PID")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, errors.New("no socket passed by systemd, LISTEN_FDS is not set")
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	// the sockets must not be passed to our own children
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		fd := listenFdsStart + i
+		syscall.CloseOnExec(fd)
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		// FileListener dups the file descriptor
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("invalid socket %s passed by systemd: %w", name, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// withH2C lets the server serve cleartext HTTP/2 connections, both with prior knowledge and HTTP/1.1 upgrades, on
+// top of HTTP/1.1 ones. The HTTP/2 server is configured on the server, so that its connections are told to go away
+// when the server shuts down, which must thus have its TLS config set beforehand.
+func withH2C(server *http.Server) error {
+	h2s := &http2.Server{}
+	// ConfigureServer sets a TLS config, while we only serve TLS when one was provided, see serve
+	tlsConfig := server.TLSConfig
+	if err := http2.ConfigureServer(server, h2s); err != nil {
+		return fmt.Errorf("unable to configure h2c: %w", err)
+	}
+	server.TLSConfig = tlsConfig
+	server.Handler = h2c.NewHandler(server.Handler, h2s)
+	return nil
+}
+
+// serve serves the listeners until the server is shut down, over TLS if the server has a TLS config, except on Unix
+// sockets which are always served in cleartext. It returns the first error of the listeners, or http.ErrServerClosed,
+// including when the listeners were closed on upgrade.
+func serve(server *http.Server, listeners []net.Listener) error {
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		useTLS := server.TLSConfig != nil && l.Addr().Network() != "unix"
+		slog.Info("listening for http requests", "network", l.Addr().Network(), "addr", l.Addr().String(), "tls", useTLS)
+		go func(l net.Listener) {
+			if useTLS {
+				errs <- server.ServeTLS(l, "", "")
+				return
+			}
+			errs <- server.Serve(l)
+		}(l)
+	}
+
+	for range listeners {
+		if err := <-errs; !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+			return err
+		}
+	}
+	return http.ErrServerClosed
+}
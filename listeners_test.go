@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+func TestListen(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "relay.sock")
+	// a stale socket of a previous run doesn't prevent us from listening
+	stale, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listeners, err := listen("127.0.0.1:0, unix:" + sock + ",")
+	require.NoError(t, err)
+	require.Len(t, listeners, 2)
+	require.Equal(t, "tcp", listeners[0].Addr().Network())
+	require.Equal(t, "unix", listeners[1].Addr().Network())
+
+	// a socket still in use by another relay is never removed
+	_, err = listen("unix:" + sock)
+	require.ErrorContains(t, err, "in use")
+	c, err := net.Dial("unix", sock)
+	require.NoError(t, err)
+	c.Close()
+
+	for _, l := range listeners {
+		l.Close()
+	}
+
+	// other files are never removed
+	file := filepath.Join(t.TempDir(), "relay.conf")
+	require.NoError(t, os.WriteFile(file, nil, 0o600))
+	_, err = listen("127.0.0.1:0,unix:" + file)
+	require.Error(t, err)
+	require.FileExists(t, file)
+
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	_, err = listen(systemdBind)
+	require.Error(t, err)
+
+	_, err = listen(" ")
+	require.Error(t, err)
+}
+
+func TestServe_MultipleListenersAndH2C(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "relay.sock")
+	listeners, err := listen("127.0.0.1:0,unix:" + sock)
+	require.NoError(t, err)
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+		if unixPeer(r) {
+			w.Write([]byte(" unix"))
+		}
+	}), ConnContext: markUnixPeers}
+	require.NoError(t, withH2C(server))
+	require.Nil(t, server.TLSConfig)
+	done := make(chan error, 1)
+	go func() { done <- serve(server, listeners) }()
+
+	get := func(c *http.Client, url string) string {
+		resp, err := c.Get(url)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	tcpURL := "http://" + listeners[0].Addr().String()
+	require.Equal(t, "HTTP/1.1", get(http.DefaultClient, tcpURL))
+
+	// HTTP/2 with prior knowledge, as load balancers speak it
+	h2c := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	require.Equal(t, "HTTP/2.0", get(h2c, tcpURL))
+
+	unix := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	require.Equal(t, "HTTP/1.1 unix", get(unix, "http://relay"))
+
+	require.NoError(t, server.Shutdown(context.Background()))
+	require.True(t, errors.Is(<-done, http.ErrServerClosed))
+}
@@ -0,0 +1,143 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/drand/drand/v2/common"
+	proto "github.com/drand/drand/v2/protobuf/drand"
+	"github.com/drand/http-relay/grpc"
+)
+
+// source is providing the beacons of a local chain, rounds are expected to be requested only once they are due.
+type source interface {
+	Beacon(round uint64) (*grpc.HexBeacon, error)
+}
+
+// Chain is serving a single beacon chain from memory, without any drand node, using the chain info round timing to
+// decide which beacons are available. It implements the same calls as the grpc.Client used by the relay handlers.
+type Chain struct {
+	info  *grpc.JsonInfoV2
+	src   source
+	clock clock
+	name  string
+}
+
+// matches returns whether the provided metadata designate our chain, the default beacon ID matching our chain when
+// it is the default one.
+func (c *Chain) matches(m *proto.Metadata) bool {
+	if hash := m.GetChainHash(); len(hash) > 0 {
+		return bytes.Equal(hash, c.info.Hash)
+	}
+	return common.CompareBeaconIDs(m.GetBeaconID(), c.info.BeaconId)
+}
+
+// current returns the latest round that was emitted on our chain.
+func (c *Chain) current() uint64 {
+	_, next := c.info.ExpectedNext()
+	return next - 1
+}
+
+// GetBeacon returns the requested beacon, asking for round 0 provides the latest one. It refuses future rounds.
+func (c *Chain) GetBeacon(_ context.Context, m *proto.Metadata, round uint64) (*grpc.HexBeacon, error) {
+	if !c.matches(m) {
+		return nil, fmt.Errorf("unknown chain: %v", m)
+	}
+
+	current := c.current()
+	if round == 0 {
+		round = current
+	}
+	if round > current {
+		return nil, fmt.Errorf("round %d is in the future, current round is %d", round, current)
+	}
+
+	return c.src.Beacon(round)
+}
+
+// Watch returns new beacons as they become available.
+func (c *Chain) Watch(ctx context.Context, m *proto.Metadata) <-chan *grpc.HexBeacon {
+	ch := make(chan *grpc.HexBeacon, 1)
+	if !c.matches(m) {
+		close(ch)
+		return ch
+	}
+	go func() {
+		defer close(ch)
+		_, nextRound := c.info.ExpectedNext()
+		for ; ; nextRound++ {
+			// round 1 happened at genesis time
+			nextTime := c.info.GenesisTime + int64(nextRound-1)*int64(c.info.Period)
+			timer := time.NewTimer(c.clock.Until(time.Unix(nextTime, 0)))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			beacon, err := c.src.Beacon(nextRound)
+			if err != nil {
+				slog.Error("local chain unable to produce beacon", "chain", c.name, "round", nextRound, "err", err)
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- beacon:
+			}
+		}
+	}()
+	return ch
+}
+
+// Next is providing you with the next beacon emitted by our chain, in a _blocking_ way.
+func (c *Chain) Next(ctx context.Context, m *proto.Metadata) (*grpc.HexBeacon, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case h, ok := <-c.Watch(ctx, m):
+		if ok {
+			return h, nil
+		}
+	}
+	return nil, fmt.Errorf("closed watch channel. ctx.Err: %w", ctx.Err())
+}
+
+// GetChainInfo returns the chain info of our chain if the metadata designate it.
+func (c *Chain) GetChainInfo(_ context.Context, m *proto.Metadata) (*grpc.JsonInfoV2, error) {
+	if !c.matches(m) {
+		return nil, fmt.Errorf("unknown chain: %v", m)
+	}
+	return c.info, nil
+}
+
+// GetChains returns our single chain hash.
+func (c *Chain) GetChains(_ context.Context) ([]string, error) {
+	return []string{hex.EncodeToString(c.info.Hash)}, nil
+}
+
+// GetBeaconIds returns our single beacon ID along with its metadata.
+func (c *Chain) GetBeaconIds(_ context.Context) ([]string, []*proto.Metadata, error) {
+	return []string{c.info.BeaconId}, []*proto.Metadata{{BeaconID: c.info.BeaconId, ChainHash: c.info.Hash}}, nil
+}
+
+// Now returns the current time on the chain clock. It should be used with grpc.SetClock when the chain is not using
+// the real time, so that the relay handlers are expecting the same rounds as the chain.
+func (c *Chain) Now() time.Time {
+	return c.clock.Now()
+}
+
+func (c *Chain) Close() error {
+	return nil
+}
+
+func (c *Chain) String() string {
+	return c.name
+}
@@ -0,0 +1,68 @@
+package local
+
+import (
+	"math"
+	"time"
+)
+
+// clock is the time source of a local chain, it allows to convert simulated durations into real ones.
+type clock interface {
+	Now() time.Time
+	// Until returns the real duration to wait until the provided time is reached on that clock.
+	Until(t time.Time) time.Duration
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Until(t time.Time) time.Duration {
+	return time.Until(t)
+}
+
+// SimulatedClock is a clock starting at a given time and running speed times faster than the real clock.
+type SimulatedClock struct {
+	origin time.Time
+	start  time.Time
+	speed  float64
+}
+
+// NewSimulatedClock returns a clock whose current time is start and that runs speed times faster than real time.
+func NewSimulatedClock(start time.Time, speed float64) *SimulatedClock {
+	return &SimulatedClock{
+		origin: time.Now(),
+		start:  start,
+		speed:  speed,
+	}
+}
+
+func (s *SimulatedClock) Now() time.Time {
+	return s.start.Add(time.Duration(float64(time.Since(s.origin)) * s.speed))
+}
+
+func (s *SimulatedClock) Until(t time.Time) time.Duration {
+	return time.Duration(float64(t.Sub(s.Now())) / s.speed)
+}
+
+// stoppingClock is a clock that stops once it reaches end, e.g. at the last round of a replayed archive.
+type stoppingClock struct {
+	clock
+	end time.Time
+}
+
+func (s stoppingClock) Now() time.Time {
+	if now := s.clock.Now(); now.Before(s.end) {
+		return now
+	}
+	return s.end
+}
+
+// Until never reaches the times after end, it returns the longest duration instead.
+func (s stoppingClock) Until(t time.Time) time.Duration {
+	if t.After(s.end) {
+		return math.MaxInt64
+	}
+	return s.clock.Until(t)
+}
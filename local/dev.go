@@ -0,0 +1,100 @@
+package local
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/drand/drand/v2/common"
+	"github.com/drand/drand/v2/crypto"
+	"github.com/drand/http-relay/grpc"
+	"github.com/drand/kyber"
+	"github.com/drand/kyber/share"
+	"github.com/drand/kyber/util/random"
+)
+
+// DevChainName is used to identify dev chains, it is notably used to mark their responses as non-production ones.
+const DevChainName = "dev-chain"
+
+// devSource signs beacons on demand using a locally generated BLS keypair. Since chained schemes require the previous
+// signature to sign a round, it keeps all the beacons it produced so far.
+type devSource struct {
+	scheme  *crypto.Scheme
+	secret  kyber.Scalar
+	seed    []byte
+	mu      sync.Mutex
+	beacons []*grpc.HexBeacon
+}
+
+// NewDevChain generates a new BLS keypair for the provided scheme and returns a Chain signing its own beacons with it,
+// starting now and emitting a new round every period. It should never be used to serve production randomness.
+func NewDevChain(schemeID string, period time.Duration, beaconID string) (*Chain, error) {
+	sch, err := crypto.SchemeFromName(schemeID)
+	if err != nil {
+		return nil, err
+	}
+	if period < time.Second || period%time.Second != 0 {
+		return nil, fmt.Errorf("invalid period %s, dev chains only support periods in whole seconds", period)
+	}
+
+	secret := sch.KeyGroup.Scalar().Pick(random.New())
+	public := sch.KeyGroup.Point().Mul(secret, nil)
+	pub, err := public.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	seed := sha256.Sum256(pub)
+
+	info := &grpc.JsonInfoV2{
+		PublicKey:   pub,
+		Period:      uint32(period.Seconds()),
+		GenesisTime: time.Now().Unix(),
+		GenesisSeed: seed[:],
+		Scheme:      sch.Name,
+		BeaconId:    common.GetCanonicalBeaconID(beaconID),
+	}
+	info.Hash = info.ComputeHash()
+
+	return &Chain{
+		info: info,
+		src: &devSource{
+			scheme: sch,
+			secret: secret,
+			seed:   seed[:],
+		},
+		clock: realClock{},
+		name:  DevChainName,
+	}, nil
+}
+
+// Beacon returns the requested round, signing all the missing rounds up to it if needed.
+func (d *devSource) Beacon(round uint64) (*grpc.HexBeacon, error) {
+	if round == 0 {
+		return nil, fmt.Errorf("invalid round 0")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for r := uint64(len(d.beacons)) + 1; r <= round; r++ {
+		b := &grpc.HexBeacon{Round: r}
+		// chained schemes are linking each round to the previous one, the first round being linked to the seed
+		if d.scheme.Name == crypto.DefaultSchemeID {
+			b.PreviousSignature = d.seed
+			if r > 1 {
+				b.PreviousSignature = d.beacons[r-2].Signature
+			}
+		}
+		// the threshold scheme prefixes signatures with the 2 bytes index of the share, we only have a single one
+		sig, err := d.scheme.ThresholdScheme.Sign(&share.PriShare{I: 0, V: d.secret}, d.scheme.DigestBeacon(b))
+		if err != nil {
+			return nil, fmt.Errorf("unable to sign round %d: %w", r, err)
+		}
+		b.Signature = sig[2:]
+		d.beacons = append(d.beacons, b)
+	}
+
+	// we return a copy since handlers are modifying the randomness of the beacons they serve
+	b := *d.beacons[round-1]
+	return &b, nil
+}
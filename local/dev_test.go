@@ -0,0 +1,81 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drand/drand/v2/crypto"
+	proto "github.com/drand/drand/v2/protobuf/drand"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDevChainBeaconsVerify(t *testing.T) {
+	for _, schemeID := range crypto.ListSchemes() {
+		t.Run(schemeID, func(t *testing.T) {
+			c, err := NewDevChain(schemeID, time.Second, "")
+			require.NoError(t, err)
+			// we pretend the chain started a while ago to have a few rounds available
+			c.info.GenesisTime -= 5
+			c.info.Hash = c.info.ComputeHash()
+
+			info, err := c.GetChainInfo(context.Background(), &proto.Metadata{BeaconID: "default"})
+			require.NoError(t, err)
+			require.Equal(t, []byte(info.Hash), info.ComputeHash())
+
+			sch, err := crypto.SchemeFromName(info.Scheme)
+			require.NoError(t, err)
+			pub := sch.KeyGroup.Point()
+			require.NoError(t, pub.UnmarshalBinary(info.PublicKey))
+
+			m := &proto.Metadata{ChainHash: info.Hash}
+			latest, err := c.GetBeacon(context.Background(), m, 0)
+			require.NoError(t, err)
+			require.GreaterOrEqual(t, latest.Round, uint64(5))
+
+			var previous []byte
+			for r := uint64(1); r <= latest.Round; r++ {
+				b, err := c.GetBeacon(context.Background(), m, r)
+				require.NoError(t, err)
+				require.Equal(t, r, b.Round)
+				require.NoError(t, sch.VerifyBeacon(b, pub))
+				if schemeID == crypto.DefaultSchemeID && previous != nil {
+					require.True(t, bytes.Equal(previous, b.PreviousSignature))
+				}
+				previous = b.Signature
+			}
+
+			_, err = c.GetBeacon(context.Background(), m, latest.Round+2)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestDevChainUnknownChain(t *testing.T) {
+	c, err := NewDevChain(crypto.UnchainedSchemeID, time.Second, "dev")
+	require.NoError(t, err)
+
+	_, err = c.GetBeacon(context.Background(), &proto.Metadata{BeaconID: "default"}, 0)
+	require.Error(t, err)
+	_, err = c.GetChainInfo(context.Background(), &proto.Metadata{ChainHash: make([]byte, 32)})
+	require.Error(t, err)
+	_, err = c.GetChainInfo(context.Background(), &proto.Metadata{BeaconID: "dev"})
+	require.NoError(t, err)
+
+	_, err = NewDevChain(crypto.UnchainedSchemeID, 1500*time.Millisecond, "")
+	require.Error(t, err)
+}
+
+func TestDevChainNext(t *testing.T) {
+	c, err := NewDevChain(crypto.ShortSigSchemeID, time.Second, "")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	m := &proto.Metadata{BeaconID: "default"}
+	_, expected := c.info.ExpectedNext()
+	next, err := c.Next(ctx, m)
+	require.NoError(t, err)
+	require.Equal(t, expected, next.Round)
+}
@@ -0,0 +1,60 @@
+package local
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/drand/http-relay/archive"
+	"github.com/drand/http-relay/grpc"
+)
+
+// ReplayName is used to identify replay chains, it is notably used to mark their responses as non-production ones.
+const ReplayName = "replay"
+
+// archiveSource serves the beacons of an archive.
+type archiveSource struct {
+	a *archive.Archive
+}
+
+func (s *archiveSource) Beacon(round uint64) (*grpc.HexBeacon, error) {
+	b, ok := s.a.Beacon(round)
+	if !ok {
+		return nil, fmt.Errorf("round %d is not part of the replayed archive", round)
+	}
+	// we return a copy since handlers are modifying the randomness of the beacons they serve
+	ret := *b
+	return &ret, nil
+}
+
+// NewReplayChain returns a Chain serving the archived beacons as if they were live. Its simulated clock starts at the
+// time of the first archived round plus the provided offset, and runs speed times faster than real time. The clock
+// stops at the last archived round, which is then served as the latest one forever while no next round comes in.
+func NewReplayChain(a *archive.Archive, offset time.Duration, speed float64) (*Chain, error) {
+	if len(a.Beacons) == 0 {
+		return nil, errors.New("cannot replay an archive without beacons")
+	}
+	if speed <= 0 {
+		return nil, fmt.Errorf("invalid replay speed %v, it must be positive", speed)
+	}
+	if a.Info.Period == 0 {
+		return nil, errors.New("invalid chain info in archive: period is 0")
+	}
+
+	// round 1 happened at genesis time
+	roundTime := func(round uint64) time.Time {
+		return time.Unix(a.Info.GenesisTime+int64(round-1)*int64(a.Info.Period), 0)
+	}
+	first, last := roundTime(a.Beacons[0].Round), roundTime(a.Beacons[len(a.Beacons)-1].Round)
+	start := first.Add(offset)
+	if start.Before(first) || start.After(last) {
+		return nil, fmt.Errorf("invalid replay offset %v, it must be between 0 and %v", offset, last.Sub(first))
+	}
+
+	return &Chain{
+		info:  a.Info,
+		src:   &archiveSource{a: a},
+		clock: stoppingClock{clock: NewSimulatedClock(start, speed), end: last},
+		name:  ReplayName,
+	}, nil
+}
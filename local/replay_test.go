@@ -0,0 +1,114 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drand/drand/v2/crypto"
+	proto "github.com/drand/drand/v2/protobuf/drand"
+	"github.com/drand/http-relay/archive"
+	"github.com/drand/http-relay/grpc"
+	"github.com/stretchr/testify/require"
+)
+
+// devArchive returns an archive of the rounds 1 to n of a new dev chain with the provided period.
+func devArchive(t *testing.T, period time.Duration, n uint64) *archive.Archive {
+	t.Helper()
+	dev, err := NewDevChain(crypto.DefaultSchemeID, period, "")
+	require.NoError(t, err)
+	a := &archive.Archive{Info: dev.info}
+	for r := uint64(1); r <= n; r++ {
+		b, err := dev.src.Beacon(r)
+		require.NoError(t, err)
+		a.Beacons = append(a.Beacons, b)
+	}
+	return a
+}
+
+func TestReplayArchive(t *testing.T) {
+	a := devArchive(t, 3*time.Second, 20)
+	var buf bytes.Buffer
+	require.NoError(t, archive.WriteJSONL(&buf, a))
+	a, err := archive.ReadJSONL(&buf)
+	require.NoError(t, err)
+	require.Len(t, a.Beacons, 20)
+
+	// we start at round 5 and emit a round every 100ms
+	c, err := NewReplayChain(a, 4*3*time.Second, 30)
+	require.NoError(t, err)
+	grpc.SetClock(c.Now)
+	t.Cleanup(func() { grpc.SetClock(time.Now) })
+
+	m := &proto.Metadata{ChainHash: a.Info.Hash}
+	latest, err := c.GetBeacon(context.Background(), m, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), latest.Round)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	previous := latest.Round
+	for b := range c.Watch(ctx, m) {
+		require.Equal(t, previous+1, b.Round)
+		expected, ok := a.Beacon(b.Round)
+		require.True(t, ok)
+		require.Equal(t, expected.Signature, b.Signature)
+		previous = b.Round
+		if previous == 10 {
+			break
+		}
+	}
+	require.Equal(t, uint64(10), previous)
+	_, next := a.Info.ExpectedNext()
+	require.GreaterOrEqual(t, next, uint64(11))
+}
+
+func TestReplayPastTheEnd(t *testing.T) {
+	// the 5 rounds are replayed within 40ms
+	a := devArchive(t, 3*time.Second, 5)
+	c, err := NewReplayChain(a, 0, 300)
+	require.NoError(t, err)
+	grpc.SetClock(c.Now)
+	t.Cleanup(func() { grpc.SetClock(time.Now) })
+
+	m := &proto.Metadata{ChainHash: a.Info.Hash}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	var rounds []uint64
+	for b := range c.Watch(ctx, m) {
+		rounds = append(rounds, b.Round)
+	}
+	require.Equal(t, []uint64{2, 3, 4, 5}, rounds)
+	require.ErrorIs(t, ctx.Err(), context.DeadlineExceeded)
+
+	// the last round stays the latest one, while the next one never comes in
+	latest, err := c.GetBeacon(context.Background(), m, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), latest.Round)
+	_, next := a.Info.ExpectedNext()
+	require.Equal(t, uint64(6), next)
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.Next(ctx, m)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestReplayInvalidArchive(t *testing.T) {
+	a := devArchive(t, time.Second, 1)
+	_, err := NewReplayChain(a, 0, 0)
+	require.Error(t, err)
+
+	// the replay must start within the archive
+	a = devArchive(t, time.Second, 3)
+	_, err = NewReplayChain(a, -time.Second, 1)
+	require.Error(t, err)
+	_, err = NewReplayChain(a, 3*time.Second, 1)
+	require.Error(t, err)
+	_, err = NewReplayChain(a, 2*time.Second, 1)
+	require.NoError(t, err)
+
+	a.Beacons = nil
+	_, err = NewReplayChain(a, 0, 1)
+	require.Error(t, err)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"log/slog"
 	"net"
@@ -15,20 +16,72 @@ import (
 	"testing"
 	"time"
 
+	"github.com/drand/drand/v2/common"
+	"github.com/drand/drand/v2/crypto"
+	"github.com/drand/http-relay/archive"
 	"github.com/drand/http-relay/grpc"
+	"github.com/drand/http-relay/local"
 )
 
 var (
-	version     = "drand-http-server-v2.2.1"
-	metricFlag  = flag.String("metrics", "localhost:9999", "The flag to set the interface for metrics. Defaults to localhost:9999")
-	httpBind    = flag.String("bind", "localhost:8080", "The address to bind the http server to")
-	grpcURL     = flag.String("grpc-connect", "localhost:4444", "The URL and port to your drand node's grpc port, e.g. pl1-rpc.testnet.drand.sh:443 you can add fallback nodes by separating them with a comma: pl1-rpc.testnet.drand.sh:443,pl2-rpc.testnet.drand.sh:443")
-	goVersion   = flag.Bool("version", false, "Displays the current server version.")
-	requireAuth = flag.Bool("enable-auth", false, "Forces JWT authentication on V2 API using the JWT secret from the AUTH_TOKEN env variable.")
-	verbose     = flag.Bool("verbose", false, "Prints as many logs as possible.")
-	jsonFlag    = flag.Bool("json", false, "Prints logs in JSON format.")
-	_           = flag.Bool("insecure", false, "deprecated flag")
-	_           = flag.String("hash-list", "", "deprecated flag")
+	version          = "drand-http-server-v2.2.1"
+	metricFlag       = flag.String("metrics", "localhost:9999", "The flag to set the interface for metrics. Defaults to localhost:9999")
+	metricsAllow     = flag.String("metrics-allow", "", "Comma-separated IPs and CIDRs allowed to access the metrics listener, all of them if empty. Basic auth or bearer token credentials can be required using the DRAND_METRICS_BASIC_AUTH (user:password) and DRAND_METRICS_TOKEN env variables.")
+	metricsCert      = flag.String("metrics-tls-cert", "", "The PEM certificate file used to serve the metrics over TLS.")
+	metricsKey       = flag.String("metrics-tls-key", "", "The PEM private key file of the --metrics-tls-cert.")
+	metricsClientCA  = flag.String("metrics-client-ca", "", "The PEM CA certificates file the metrics clients certificates must be signed by, enabling mTLS. Requires --metrics-tls-cert.")
+	httpBind         = flag.String("bind", "localhost:8080", "The comma-separated addresses to bind the http server to: TCP addresses, Unix sockets paths prefixed by unix:, e.g. unix:/run/relay.sock, or systemd for the sockets passed by systemd socket activation.")
+	h2cFlag          = flag.Bool("h2c", false, "Serves cleartext HTTP/2 (h2c) connections as well as HTTP/1.1 ones, e.g. for load balancers multiplexing many requests over few connections.")
+	tlsCert          = flag.String("tls-cert", "", "The PEM certificate file used to serve the relay over HTTPS, reloaded when it changes. Plain HTTP is served if empty.")
+	tlsKey           = flag.String("tls-key", "", "The PEM private key file of the --tls-cert, reloaded when it changes.")
+	tlsMinVersion    = flag.String("tls-min-version", "1.2", "The minimum TLS version accepted: 1.0, 1.1, 1.2 or 1.3.")
+	tlsCiphers       = flag.String("tls-ciphers", "", "Comma-separated TLS 1.2 cipher suites accepted, e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, the Go defaults if empty. TLS 1.3 cipher suites are not configurable.")
+	tlsClientCA      = flag.String("tls-client-ca", "", "The PEM CA certificates file the clients certificates must be signed by, enabling mTLS.")
+	tlsClientAuth    = flag.String("tls-client-auth", "require", "Whether client certificates are required with --tls-client-ca, or only verified when presented: require or optional.")
+	grpcURL          = flag.String("grpc-connect", "localhost:4444", "The URL and port to your drand node's grpc port, e.g. pl1-rpc.testnet.drand.sh:443 you can add fallback nodes by separating them with a comma: pl1-rpc.testnet.drand.sh:443,pl2-rpc.testnet.drand.sh:443")
+	goVersion        = flag.Bool("version", false, "Displays the current server version.")
+	requireAuth      = flag.Bool("enable-auth", false, "Forces JWT authentication on V2 API using the JWT secret from the DRAND_AUTH_KEY env variable and/or the --auth-jwks keys.")
+	v1Auth           = flag.Bool("v1-auth", false, "Requires the same authentication as the V2 API, set using --enable-auth and --auth-api-keys, on the V1 API.")
+	v1Allow          = flag.String("v1-allow", "", "Comma-separated IPs and CIDRs allowed to access the V1 API, all of them if empty.")
+	v2Allow          = flag.String("v2-allow", "", "Comma-separated IPs and CIDRs allowed to access the V2 API, all of them if empty.")
+	jwksSource       = flag.String("auth-jwks", "", "A local file or http(s) URL serving the JWKS of the public keys allowed to sign RS256, ES256 and EdDSA JWTs, selected using their kid.")
+	jwksRefresh      = flag.Duration("auth-jwks-refresh", 5*time.Minute, "How often the --auth-jwks key set is reloaded, 0 disables it.")
+	authLeeway       = flag.Duration("auth-leeway", 30*time.Second, "The clock skew tolerated when validating the exp, nbf and iat claims of JWTs.")
+	authExpReq       = flag.Bool("auth-require-exp", false, "Refuses the JWTs without an exp claim, i.e. the ones that never expire.")
+	authIssuer       = flag.String("auth-issuer", "", "When set, JWTs must have this iss claim.")
+	authAud          = flag.String("auth-audience", "", "When set, JWTs must include this value in their aud claim.")
+	apiKeysFile      = flag.String("auth-api-keys", "", "A JSON file of the salted hashes of the API keys accepted in the X-API-Key header on the V2 API, reloaded when it changes. JWTs are only accepted as well with --enable-auth.")
+	authPresigned    = flag.Bool("auth-presigned", false, "Accepts the V2 API URLs presigned using the DRAND_AUTH_KEY secret, e.g. using jwtissuer presign, without any Authorization header.")
+	authPresignedMax = flag.Duration("auth-presigned-max", 24*time.Hour, "Refuses the presigned URLs expiring more than that after they were issued, 0 disables the check.")
+	lockoutFailures  = flag.Int("auth-lockout-failures", 0, "Locks out the client IPs failing to authenticate that many times per --auth-lockout-window, 0 disables lockouts.")
+	lockoutWindow    = flag.Duration("auth-lockout-window", time.Minute, "The window over which authentication failures are counted.")
+	lockoutDuration  = flag.Duration("auth-lockout-duration", 5*time.Minute, "How long client IPs are locked out for.")
+	proxiesFlag      = flag.String("trusted-proxies", "", "Comma-separated list of the IPs and CIDRs of the reverse proxies, e.g. TLS terminators, whose X-Forwarded-For header is used to find the client IPs for allowlists, lockouts and rate limits.")
+	authRevoked      = flag.String("auth-revoked", "", "A file listing the revoked JWT IDs (jti claims), one per line, reloaded when it changes.")
+	rateLimitFile    = flag.String("rate-limits", "", "A JSON file setting the rate, burst and concurrent limits of each tier of the V2 API, e.g. {\"anonymous\": {\"rate\": 1, \"burst\": 5, \"concurrent\": 2}}.")
+	usageFile        = flag.String("usage-file", "", "Records the requests, errors and bytes served to each JWT subject per hour and endpoint in that file, exporting usage reports on the metrics /usage endpoint. Requires --enable-auth or --auth-api-keys.")
+	usageFlush       = flag.Duration("usage-flush", time.Minute, "How often the usage counters are persisted to the --usage-file.")
+	usageKeep        = flag.Duration("usage-retention", 90*24*time.Hour, "How long the hourly usage counters are kept in the --usage-file, 0 keeps them forever.")
+	usageMetrics     = flag.Bool("usage-metrics", false, "Also exposes the usage of each JWT subject as Prometheus metrics, beware of their cardinality.")
+	verbose          = flag.Bool("verbose", false, "Prints as many logs as possible.")
+	jsonFlag         = flag.Bool("json", false, "Prints logs in JSON format.")
+	logRedact        = flag.String("log-redact", "", "Comma-separated regular expressions whose matches are redacted from all logs, in addition to the JWTs, Authorization headers, API keys and secrets that always are.")
+	devChain         = flag.Bool("dev-chain", false, "Serves a locally generated chain instead of connecting to drand nodes. NEVER use it in production.")
+	devScheme        = flag.String("dev-chain-scheme", crypto.SigsOnG1ID, "The scheme used by the dev chain, one of: "+strings.Join(crypto.ListSchemes(), ", "))
+	devPeriod        = flag.Duration("dev-chain-period", 3*time.Second, "The period of the dev chain, in whole seconds.")
+	replayFile       = flag.String("replay", "", "Serves the beacons of the provided archive file as if they were live instead of connecting to drand nodes.")
+	replayDelay      = flag.Duration("replay-offset", 0, "The offset from the time of the first archived round at which the replay starts.")
+	replaySpeed      = flag.Float64("replay-speed", 1, "How many times faster than real time the replay runs, e.g. 30 on a 3s chain emits a round every 100ms.")
+	trustFile        = flag.String("chain-trust-file", "", "Pins the first chain info seen for each chain in that file, refusing any backend disagreeing with it later on.")
+	quorumK          = flag.Int("quorum", 0, "The number of backends that must send identical beacons and chain infos on the --quorum-routes, 0 disables it.")
+	quorumPaths      = flag.String("quorum-routes", "", "Comma-separated list of URL path prefixes, e.g. /v2/beacons/quicknet, on which all reads are --quorum reads.")
+	divInterval      = flag.Duration("divergence-interval", time.Minute, "How often all the nodes are compared in the background to detect forks, stuck nodes or misconfigurations, 0 disables it.")
+	divMaxLag        = flag.Uint64("divergence-max-lag", 2, "The number of rounds a node can be behind the most advanced one before being reported as diverging.")
+	divSamples       = flag.Int("divergence-samples", 3, "The number of random past rounds compared across all nodes on each divergence check.")
+	shutdownDelay    = flag.Duration("shutdown-delay", 0, "How long /readyz fails before the relay stops accepting connections on shutdown, which should exceed the load balancer probe interval.")
+	readyMaxLag      = flag.Uint64("ready-max-lag", 2, "The number of rounds a backend can be behind the expected current round and still count towards /readyz.")
+	_                = flag.Bool("insecure", false, "deprecated flag")
+	_                = flag.String("hash-list", "", "deprecated flag")
 )
 
 func init() {
@@ -37,7 +90,10 @@ func init() {
 		return
 	}
 	flag.Parse()
-	slog.SetLogLoggerLevel(getLogLevel())
+	if err := logRedactor.add(*logRedact); err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(slog.New(newLogHandler(os.Stderr)))
 }
 
 func main() {
@@ -45,59 +101,127 @@ func main() {
 		log.Fatal("drand http server version: ", version)
 	}
 
-	nodesAddr := strings.Split(*grpcURL, ",")
-	for _, nodeAdd := range nodesAddr {
-		_, _, err := net.SplitHostPort(nodeAdd)
-		if err != nil {
-			log.Fatalf("Unable to parse --grpc flag correctly, please provide valid node URLs. On %q, got err: %v", nodeAdd, err)
-		}
+	var err error
+	if trustedProxies, err = parseAllowlist(*proxiesFlag); err != nil {
+		log.Fatal("unable to parse --trusted-proxies: ", err)
 	}
 
-	client, err := grpc.NewClient("fallback:///"+*grpcURL, slog.Default())
+	// the client is only closed once the handlers using it returned
+	client, err := newClient()
 	if err != nil {
-		log.Fatal("Failed to create client", "address", nodesAddr, "error", err)
+		log.Fatal(err)
 	}
-	defer client.Close()
 
-	go serveMetrics()
+	// Server run context, the background watchers stop once it is done
+	serverCtx, serverStopCtx := context.WithCancel(context.Background())
+
+	if *usageFile != "" {
+		if !*requireAuth && *apiKeysFile == "" {
+			log.Fatal("--usage-file requires --enable-auth or --auth-api-keys")
+		}
+		if usage, err = loadUsage(*usageFile, *usageKeep, *usageMetrics); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// the TLS flags are useless without a certificate, we don't silently serve plain HTTP
+	if *tlsCert == "" && (*tlsKey != "" || *tlsClientCA != "") {
+		log.Fatal("--tls-key and --tls-client-ca require --tls-cert")
+	}
+	if (*metricsCert == "") != (*metricsKey == "") {
+		log.Fatal("--metrics-tls-cert and --metrics-tls-key must be set together")
+	}
+	if *metricsClientCA != "" && *metricsCert == "" {
+		log.Fatal("--metrics-client-ca requires --metrics-tls-cert")
+	}
+	// the metrics server is optional, we keep serving without it
+	metricsL, err := listenMetrics()
+	if err != nil {
+		slog.Error("error serving http metrics", "addr", *metricFlag, "err", err)
+	} else {
+		go serveMetrics(serverCtx, metricsL)
+	}
 
 	slog.Info("Starting http relay", "version", version, "client", client)
 
+	listeners, err := listen(*httpBind)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// The HTTP Server
-	server := &http.Server{Addr: *httpBind, Handler: drandHandler(client)}
+	server := &http.Server{Handler: trackHandlers(drandHandler(serverCtx, client)), ConnContext: markUnixPeers}
 
-	// Server run context
-	serverCtx, serverStopCtx := context.WithCancel(context.Background())
+	if c, ok := client.(*grpc.Client); ok && len(c.KnownChains()) == 0 {
+		// we are not ready until a node answers, see Readyz
+		go c.DiscoverChains(serverCtx, discoveryBackoff, discoveryMaxBackoff)
+	}
+
+	if c, ok := client.(*grpc.Client); ok && *divInterval > 0 && c.Backends() > 1 {
+		go c.WatchDivergence(serverCtx, *divInterval, *divMaxLag, *divSamples)
+	}
+
+	var stopUsage func()
+	if usage != nil {
+		stopUsage = usage.start(serverCtx, *usageFlush)
+	}
 
 	// Listen for syscall signals for process to exit gracefully
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGUSR2)
+	handedOff := false
 	go func() {
 		defer serverStopCtx()
 		s := <-sig
-
-		slog.Info("Caught interrupt, shutting down...", "signal", s.String())
-
-		// Shutdown signal with grace period of 30 seconds
-		shutdownCtx, cancel := context.WithTimeout(serverCtx, 30*time.Second)
-		defer cancel()
-		go func() {
-			<-shutdownCtx.Done()
-			if errors.Is(shutdownCtx.Err(), context.DeadlineExceeded) {
-				slog.Error("graceful shutdown timed out.. forcing exit")
-				return
+		for s == syscall.SIGUSR2 {
+			// the usage counters are loaded by the upgraded relay, requests finishing while we drain are not counted and
+			// we stop persisting them so that we never overwrite its usage file
+			if usage != nil {
+				stopUsage()
+				if err := usage.flush(); err != nil {
+					slog.Error("unable to persist usage counters", "err", err)
+				}
 			}
-		}()
-
-		// Trigger graceful shutdown
-		if err := server.Shutdown(shutdownCtx); err != nil {
-			slog.Error("server Shutdown error", "err", err)
-			return
+			if err := upgrade(listeners, metricsL); err != nil {
+				slog.Error("upgrade failed, still serving", "err", err)
+				if usage != nil {
+					stopUsage = usage.start(serverCtx, *usageFlush)
+				}
+				s = <-sig
+				continue
+			}
+			handedOff = true
+			slog.Info("handed our listeners off to the upgraded relay, draining in-flight requests")
+			break
 		}
+		// load balancers stop sending us new requests while we drain the in-flight ones
+		shuttingDown.Store(true)
+		if !handedOff {
+			slog.Info("Caught interrupt, shutting down...", "signal", s.String())
+			// the upgraded relay already serves our sockets, otherwise we keep serving until the load balancers
+			// noticed that /readyz fails
+			time.Sleep(*shutdownDelay)
+		}
+
+		// Shutdown signal with grace period of 30 seconds
+		shutdown(serverCtx, server, shutdownGrace, drainMargin)
 	}()
 
 	// Run the server
-	err = server.ListenAndServe()
+	if *tlsCert != "" {
+		if server.TLSConfig, err = serverTLSConfig(serverCtx); err != nil {
+			log.Fatal(err)
+		}
+		slog.Info("serving the relay over HTTPS", "min_version", *tlsMinVersion, "mtls", *tlsClientCA != "")
+	}
+	if *h2cFlag {
+		// h2c requests are counted one by one, not per connection
+		if err := withH2C(server); err != nil {
+			log.Fatal(err)
+		}
+	}
+	signalReady()
+	err = serve(server, listeners)
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		slog.Error("server error", "err", err)
 		return
@@ -105,9 +229,102 @@ func main() {
 
 	// Wait for server context to be stopped
 	<-serverCtx.Done()
+	if !waitHandlers(drainMargin) {
+		slog.Error("closing the client while requests are still being handled", "requests", handlers.Load())
+	}
+	if err := client.Close(); err != nil {
+		slog.Error("error closing client", "err", err)
+	}
+	// the upgraded relay owns the usage file once we handed off
+	if usage != nil && !handedOff {
+		if err := usage.flush(); err != nil {
+			slog.Error("unable to persist usage counters", "err", err)
+		}
+	}
 	slog.Info("drand http server stopped")
 }
 
+const (
+	// discoveryBackoff is the delay before retrying to discover the chains when no node answered at startup, it is
+	// doubled on every failure up to discoveryMaxBackoff.
+	discoveryBackoff    = time.Second
+	discoveryMaxBackoff = time.Minute
+)
+
+// newClient returns the Client used to serve beacons, connecting to the drand nodes set with --grpc-connect unless
+// we are running in dev-chain mode.
+func newClient() (Client, error) {
+	if *devChain && *replayFile != "" {
+		return nil, errors.New("--dev-chain and --replay cannot be used together")
+	}
+
+	if *devChain {
+		slog.Warn("Running in dev-chain mode, the served randomness is NOT production randomness", "scheme", *devScheme, "period", *devPeriod)
+		return local.NewDevChain(*devScheme, *devPeriod, common.DefaultBeaconID)
+	}
+
+	if *replayFile != "" {
+		slog.Warn("Running in replay mode, the served beacons are NOT live", "archive", *replayFile, "offset", *replayDelay, "speed", *replaySpeed)
+		a, err := archive.Load(*replayFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load archive to replay: %w", err)
+		}
+		c, err := local.NewReplayChain(a, *replayDelay, *replaySpeed)
+		if err != nil {
+			return nil, err
+		}
+		// the relay handlers need to expect the same rounds as the ones being replayed
+		grpc.SetClock(c.Now)
+		return c, nil
+	}
+
+	nodesAddr := strings.Split(*grpcURL, ",")
+	for _, nodeAdd := range nodesAddr {
+		_, _, err := net.SplitHostPort(nodeAdd)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse --grpc flag correctly, please provide valid node URLs. On %q, got err: %w", nodeAdd, err)
+		}
+	}
+
+	var opts []grpc.Option
+	if *trustFile != "" {
+		ts, err := grpc.LoadTrustStore(*trustFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load chain trust file: %w", err)
+		}
+		opts = append(opts, grpc.WithTrustStore(ts))
+	}
+
+	if *quorumK < 0 || *quorumK > len(nodesAddr) {
+		return nil, fmt.Errorf("invalid --quorum %d, it must be between 0 and the %d configured nodes", *quorumK, len(nodesAddr))
+	}
+	if *quorumPaths != "" && *quorumK == 0 {
+		return nil, errors.New("--quorum-routes requires a --quorum of at least 1")
+	}
+
+	// the default logger is redacting credentials, see newLogHandler
+	client, err := grpc.NewClient("fallback:///"+*grpcURL, slog.Default(), opts...)
+	if errors.Is(err, grpc.ErrChainInfoMismatch) {
+		// retrying won't help, the trust file or the nodes must be fixed
+		return nil, fmt.Errorf("failed to create client for %v: %w", nodesAddr, err)
+	} else if err != nil {
+		// the chains are discovered in the background, see DiscoverChains
+		slog.Warn("unable to discover the chains, starting up degraded", "nodes", nodesAddr, "err", err)
+	}
+	return client, nil
+}
+
+// nonProductionMode returns the name of the local chain mode we are running in, if any.
+func nonProductionMode() string {
+	switch {
+	case *devChain:
+		return local.DevChainName
+	case *replayFile != "":
+		return local.ReplayName
+	}
+	return ""
+}
+
 func getLogLevel() slog.Level {
 	if *verbose {
 		return slog.LevelDebug
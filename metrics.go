@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log/slog"
+	"net"
 	"net/http"
 
 	"github.com/drand/http-relay/grpc"
@@ -36,9 +39,69 @@ var (
 		Name: "http_in_flight",
 		Help: "A gauge of requests currently being served.",
 	})
+
+	// RateLimitRequests (HTTP) how many requests were allowed or limited, per rate limiting tier
+	RateLimitRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_rate_limit_requests",
+		Help: "Number of HTTP requests allowed or limited by the rate limiter, per tier",
+	}, []string{"tier", "result"})
+
+	// RateLimitInFlight (HTTP) how many long-lived requests are being served, per rate limiting tier
+	RateLimitInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_rate_limit_in_flight",
+		Help: "A gauge of the long-lived requests currently being served, per tier",
+	}, []string{"tier"})
+
+	// AuthFailures (HTTP) how many requests failed to authenticate, per reason
+	AuthFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_auth_failures",
+		Help: "Number of HTTP requests that failed to authenticate, per reason",
+	}, []string{"reason"})
+
+	// AuthLockouts (HTTP) how many times a client IP was locked out after too many authentication failures
+	AuthLockouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "http_auth_lockouts",
+		Help: "Number of client IPs locked out after too many authentication failures",
+	})
+
+	// AuthFailingClients (HTTP) how many client IPs, subjects and locked out IPs have authentication failures in their
+	// current window
+	AuthFailingClients = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "http_auth_failing_ips",
+		Help: "A gauge of the client IPs that failed to authenticate during the current lockout window",
+	}, func() float64 {
+		ips, _, _ := auditor.counts()
+		return float64(ips)
+	})
+	AuthFailingSubjects = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "http_auth_failing_subjects",
+		Help: "A gauge of the token subjects that failed to authenticate during the current lockout window",
+	}, func() float64 {
+		_, subjects, _ := auditor.counts()
+		return float64(subjects)
+	})
+	AuthLockedOut = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "http_auth_locked_out_ips",
+		Help: "A gauge of the client IPs currently locked out after too many authentication failures",
+	}, func() float64 {
+		_, _, locked := auditor.counts()
+		return float64(locked)
+	})
+
+	// UsageRequests (HTTP) how many requests each JWT subject made, only registered with --usage-metrics
+	UsageRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_usage_requests",
+		Help: "Number of authenticated HTTP requests, per JWT subject and endpoint",
+	}, []string{"subject", "endpoint"})
+
+	// UsageBytes (HTTP) how many response bytes each JWT subject received, only registered with --usage-metrics
+	UsageBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_usage_bytes",
+		Help: "Number of response bytes of the authenticated HTTP requests, per JWT subject",
+	}, []string{"subject"})
 )
 
-func serveMetrics() {
+func serveMetrics(ctx context.Context, l net.Listener) {
 	bindMetrics()
 	handler := promhttp.HandlerFor(prometheus.Gatherers{HTTPMetrics, grpc.ClientMetrics}, promhttp.HandlerOpts{
 		Registry: HTTPMetrics,
@@ -52,18 +115,42 @@ func serveMetrics() {
 		return
 	}
 
+	// we use our own mux rather than the default one, so that nothing is exposed without protectMetrics
+	mux := http.NewServeMux()
 	slog.Info("starting to serve metrics on /metrics")
-	http.Handle("/metrics", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/metrics", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		slog.Debug("serving metrics on /metrics")
 		grpc.UpdateMetrics(mClient)
 		handler.ServeHTTP(w, r)
 	}))
-	http.Handle("/chanz", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+	if usage != nil {
+		slog.Info("serving usage reports on /usage")
+		mux.Handle("/usage", usage)
+	}
+	if *requireAuth || *apiKeysFile != "" {
+		mux.Handle("/auth-failures", auditor)
+	}
+	mux.Handle("/chanz", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		slog.Debug("display channelz data on /chanz")
 		w.Write([]byte(grpc.UpdateMetrics(mClient)))
 	}))
-	//nolint:gosec // Ignoring G114
-	if err := http.ListenAndServe(*metricFlag, nil); err != nil {
+
+	//nolint:gosec // Ignoring G112
+	server := &http.Server{Handler: protectMetrics(mux)}
+	if *metricsCert != "" {
+		if server.TLSConfig, err = metricsTLSConfig(ctx); err != nil {
+			slog.Error("error serving http metrics", "addr", *metricFlag, "err", err)
+			return
+		}
+		err = server.ServeTLS(l, "", "")
+	} else {
+		err = server.Serve(l)
+	}
+	if errors.Is(err, net.ErrClosed) {
+		// our listener was handed off to an upgraded relay
+		slog.Info("stopped serving http metrics")
+		return
+	} else if err != nil {
 		slog.Error("error serving http metrics", "addr", *metricFlag, "err", err)
 		return
 	}
@@ -75,6 +162,17 @@ func bindMetrics() {
 		HTTPCallCounter,
 		HTTPLatency,
 		HTTPInFlight,
+		RateLimitRequests,
+		RateLimitInFlight,
+		AuthFailures,
+		AuthLockouts,
+		AuthFailingClients,
+		AuthFailingSubjects,
+		AuthLockedOut,
+	}
+	if *usageMetrics {
+		// per-subject metrics have an unbounded cardinality, hence they are opt-in
+		httpMetrics = append(httpMetrics, UsageRequests, UsageBytes)
 	}
 	for _, c := range httpMetrics {
 		if err := HTTPMetrics.Register(c); err != nil {
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -30,8 +33,9 @@ func prometheusMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// drandHandler is setting all the routes and middleware we need for a drand relay
-func drandHandler(client *grpc.Client) http.Handler {
+// drandHandler is setting all the routes and middleware we need for a drand relay, the files they rely on being
+// watched until ctx is done.
+func drandHandler(ctx context.Context, client Client) http.Handler {
 	// setup the chi router
 	r := chi.NewRouter()
 
@@ -48,9 +52,13 @@ func drandHandler(client *grpc.Client) http.Handler {
 		QuietDownRoutes: []string{
 			"/",
 			"/ping",
+			"/livez",
+			"/readyz",
 		},
 		QuietDownPeriod: 1 * time.Second,
 	})
+	// verbose mode logs the response headers, and the request URLs may be presigned
+	logger.Logger = slog.New(&redactHandler{Handler: logger.Logger.Handler(), r: logRedactor})
 
 	logger.Info("logger instantiated", "LogLevel", getLogLevel())
 
@@ -63,13 +71,27 @@ func drandHandler(client *grpc.Client) http.Handler {
 	// Basic CORS
 	r.Use(cors.AllowAll().Handler)
 
+	if mode := nonProductionMode(); mode != "" {
+		// local chains are not serving real randomness, we make sure every response says so
+		r.Use(markNonProduction(mode))
+	}
+
+	if *replayFile != "" {
+		// replayed rounds are not following the real time, so we cannot let anyone cache them
+		r.Use(disableCaching)
+	}
+
 	if *verbose {
 		// when running in verbose mode, we have a special Debug log telling us for each request whether it was matched
 		// or not by Chi against a given route.
 		r.Use(trackRoute)
 	}
 
-	SetupRoutes(r, client)
+	SetupRoutes(ctx, r, client)
+
+	// the health endpoints for load balancers and orchestrators, without ACLs like /ping
+	r.Get("/livez", Livez)
+	r.Get("/readyz", Readyz(client))
 
 	// we explicitly don't serve favicon
 	r.Get("/favicon.ico", http.NotFound)
@@ -86,6 +108,91 @@ func trackRoute(next http.Handler) http.Handler {
 	})
 }
 
+// markNonProduction is flagging all responses with a header telling clients they are not getting production randomness.
+func markNonProduction(mode string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Drand-Non-Production", mode)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// quorumHeader is the header allowing clients to request quorum reads, its value being the number of backends that
+// must agree on the response. It is also set on all responses served using quorum reads.
+const quorumHeader = "X-Drand-Quorum"
+
+// quorumReads is requesting quorum reads from the Client on the --quorum-routes, and for the requests setting the
+// quorumHeader. Clients can only raise the quorum of a route, never lower it. Since quorum reads query all the
+// backends, the quorumHeader is only honoured for authenticated clients, or for all of them when rateLimited.
+func quorumReads(backends int, rateLimited bool) func(http.Handler) http.Handler {
+	var prefixes []string
+	for _, p := range strings.Split(*quorumPaths, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			prefixes = append(prefixes, p)
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// responses may differ depending on the requested quorum, caches must not mix them
+			w.Header().Add("Vary", quorumHeader)
+
+			k := 0
+			for _, p := range prefixes {
+				if strings.HasPrefix(r.URL.Path, p) {
+					k = *quorumK
+					break
+				}
+			}
+
+			if h := r.Header.Get(quorumHeader); h != "" {
+				if _, ok := claimsFrom(r.Context()); !ok && !rateLimited {
+					http.Error(w, fmt.Sprintf("The %s header requires authentication", quorumHeader), http.StatusUnauthorized)
+					return
+				}
+				hk, err := strconv.Atoi(h)
+				if err != nil || hk < 1 || hk > backends {
+					http.Error(w, fmt.Sprintf("Invalid %s header, expected a number of backends between 1 and %d", quorumHeader, backends), http.StatusBadRequest)
+					return
+				}
+				k = max(k, hk)
+			}
+
+			if k > 0 {
+				w.Header().Set(quorumHeader, strconv.Itoa(k))
+				r = r.WithContext(grpc.WithQuorum(r.Context(), k))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// disableCaching is overriding the Cache-Control header set by our handlers.
+func disableCaching(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&noCacheWriter{w}, r)
+	})
+}
+
+type noCacheWriter struct {
+	http.ResponseWriter
+}
+
+func (n *noCacheWriter) WriteHeader(code int) {
+	n.Header().Set("Cache-Control", "no-store")
+	n.ResponseWriter.WriteHeader(code)
+}
+
+func (n *noCacheWriter) Write(b []byte) (int, error) {
+	n.Header().Set("Cache-Control", "no-store")
+	return n.ResponseWriter.Write(b)
+}
+
+func (n *noCacheWriter) Unwrap() http.ResponseWriter {
+	return n.ResponseWriter
+}
+
 // addCommonHeaders is setting the json and CORS headers for drand json outputs
 func addCommonHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
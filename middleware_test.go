@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/drand/http-relay/grpc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuorumReads(t *testing.T) {
+	setFlag(t, quorumK, 2)
+	setFlag(t, quorumPaths, "/v2/beacons/quicknet")
+
+	var got int
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = grpc.QuorumFrom(r.Context())
+	})
+	serve := func(mw func(http.Handler) http.Handler, r *http.Request) *httptest.ResponseRecorder {
+		got = 0
+		w := httptest.NewRecorder()
+		mw(h).ServeHTTP(w, r)
+		return w
+	}
+	withHeader := func(path, k string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set(quorumHeader, k)
+		return r
+	}
+
+	// the --quorum-routes are quorum reads for everyone
+	w := serve(quorumReads(3, false), httptest.NewRequest(http.MethodGet, "/v2/beacons/quicknet/rounds/1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, 2, got)
+	require.Equal(t, "2", w.Header().Get(quorumHeader))
+
+	// anonymous clients cannot make every request fan out to all the backends
+	w = serve(quorumReads(3, false), withHeader("/v2/beacons/evmnet/rounds/1", "3"))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Zero(t, got)
+
+	// unless they are rate limited
+	w = serve(quorumReads(3, true), withHeader("/v2/beacons/evmnet/rounds/1", "3"))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, 3, got)
+
+	// authenticated clients can raise the quorum, but not lower it
+	w = serve(quorumReads(3, false), withClaims(withHeader("/v2/beacons/quicknet/rounds/1", "1"), &scopeClaims{}))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, 2, got)
+	w = serve(quorumReads(3, false), withClaims(withHeader("/v2/beacons/quicknet/rounds/1", "4"), &scopeClaims{}))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestWriteBeacon_NextIsNotAQuorumRead(t *testing.T) {
+	w := httptest.NewRecorder()
+	w.Header().Set(quorumHeader, "2")
+	writeBeacon(w, &grpc.HexBeacon{Round: 1}, -1, true)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Empty(t, w.Header().Get(quorumHeader))
+
+	w = httptest.NewRecorder()
+	w.Header().Set(quorumHeader, "2")
+	writeBeacon(w, &grpc.HexBeacon{Round: 1}, 0, true)
+	require.Equal(t, "2", w.Header().Get(quorumHeader))
+}
@@ -0,0 +1,155 @@
+// Package presign signs and verifies time-limited relay URLs, allowing browsers and CDNs to access the protected V2
+// API without any Authorization header. The signature is an HMAC-SHA256 of the URL path, or of a path prefix, its
+// issue time, its expiry and its optional subject, using the DRAND_AUTH_KEY secret of the relays.
+package presign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The query parameters of presigned URLs.
+const (
+	IssuedParam    = "iat"
+	ExpiresParam   = "expires"
+	SubjectParam   = "sub"
+	PrefixParam    = "prefix"
+	SignatureParam = "signature"
+)
+
+var (
+	// ErrInvalidSignature is returned for URLs that are not signed, or not signed using our secret.
+	ErrInvalidSignature = errors.New("invalid URL signature")
+	// ErrExpired is returned for URLs past their expiry.
+	ErrExpired = errors.New("expired URL signature")
+	// ErrTooLong is returned for URLs issued for longer than the maximum validity accepted by the relay.
+	ErrTooLong = errors.New("URL signature valid for too long")
+)
+
+type options struct {
+	subject string
+	prefix  string
+	issued  time.Time
+}
+
+// Option allows to set the optional parameters of a presigned URL when calling Sign.
+type Option func(*options)
+
+// WithSubject sets the subject of the URL, used by the relay for rate limiting and usage accounting.
+func WithSubject(subject string) Option {
+	return func(o *options) {
+		o.subject = subject
+	}
+}
+
+// WithPrefix makes the signature valid for all the paths under prefix, e.g. /v2/beacons/quicknet for all the rounds
+// of a chain, instead of only the path of the URL.
+func WithPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = prefix
+	}
+}
+
+// WithIssuedAt sets the issue time of the URL, which is the time of the call to Sign by default.
+func WithIssuedAt(issued time.Time) Option {
+	return func(o *options) {
+		o.issued = issued
+	}
+}
+
+// mac returns the hex-encoded signature of a presigned URL. Its scope is either its path or its prefix, tagged so
+// that a signature for one cannot be used as the other.
+func mac(secret []byte, scope, issued, expires, subject string) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte("drand-presign-v2\n" + scope + "\n" + issued + "\n" + expires + "\n" + subject))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// inPrefix returns whether the path is under the prefix, matching whole path segments only.
+func inPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+// Sign returns the provided URL presigned using secret until expires.
+func Sign(secret []byte, rawURL string, expires time.Time, opts ...Option) (string, error) {
+	o := options{issued: time.Now()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL to sign: %w", err)
+	}
+	scope := "path:" + u.EscapedPath()
+	q := u.Query()
+	for _, p := range []string{IssuedParam, ExpiresParam, SubjectParam, PrefixParam, SignatureParam} {
+		q.Del(p)
+	}
+	if o.prefix != "" {
+		if !inPrefix(u.EscapedPath(), o.prefix) {
+			return "", fmt.Errorf("URL path %q is not under prefix %q", u.EscapedPath(), o.prefix)
+		}
+		scope = "prefix:" + o.prefix
+		q.Set(PrefixParam, o.prefix)
+	}
+	if o.subject != "" {
+		q.Set(SubjectParam, o.subject)
+	}
+	iat := strconv.FormatInt(o.issued.Unix(), 10)
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	q.Set(IssuedParam, iat)
+	q.Set(ExpiresParam, exp)
+	q.Set(SignatureParam, mac(secret, scope, iat, exp, o.subject))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Signed returns whether the URL carries a signature, in which case it is meant to be checked using Verify.
+func Signed(u *url.URL) bool {
+	return u.Query().Has(SignatureParam)
+}
+
+// Verify checks the signature of the URL at the provided time, refusing the ones whose expiry is more than maxValidity
+// after their issue time if it is not zero, and returns its subject and expiry.
+func Verify(secret []byte, u *url.URL, now time.Time, maxValidity time.Duration) (string, time.Time, error) {
+	q := u.Query()
+	iat, err := strconv.ParseInt(q.Get(IssuedParam), 10, 64)
+	if err != nil {
+		return "", time.Time{}, ErrInvalidSignature
+	}
+	exp, err := strconv.ParseInt(q.Get(ExpiresParam), 10, 64)
+	if err != nil {
+		return "", time.Time{}, ErrInvalidSignature
+	}
+	scope := "path:" + u.EscapedPath()
+	if prefix := q.Get(PrefixParam); prefix != "" {
+		if !inPrefix(u.EscapedPath(), prefix) {
+			return "", time.Time{}, ErrInvalidSignature
+		}
+		scope = "prefix:" + prefix
+	}
+	subject := q.Get(SubjectParam)
+
+	expected := mac(secret, scope, q.Get(IssuedParam), q.Get(ExpiresParam), subject)
+	if !hmac.Equal([]byte(expected), []byte(q.Get(SignatureParam))) {
+		return "", time.Time{}, ErrInvalidSignature
+	}
+
+	expires := time.Unix(exp, 0)
+	if !now.Before(expires) {
+		return "", time.Time{}, ErrExpired
+	}
+	// we bound how long the URL lives rather than its remaining validity, which would accept any URL eventually
+	if maxValidity > 0 && expires.Sub(time.Unix(iat, 0)) > maxValidity {
+		return "", time.Time{}, ErrTooLong
+	}
+	return subject, expires, nil
+}
@@ -0,0 +1,92 @@
+package presign
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignVerify(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1_700_000_000, 0)
+	expires := now.Add(time.Hour)
+
+	verify := func(raw string) (string, error) {
+		u, err := url.Parse(raw)
+		require.NoError(t, err)
+		sub, _, err := Verify(secret, u, now, 24*time.Hour)
+		return sub, err
+	}
+
+	signed, err := Sign(secret, "https://relay.example.com/v2/beacons/quicknet/rounds/latest", expires, WithSubject("cdn"), WithIssuedAt(now))
+	require.NoError(t, err)
+	u, err := url.Parse(signed)
+	require.NoError(t, err)
+	require.True(t, Signed(u))
+	sub, exp, err := Verify(secret, u, now, 24*time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, "cdn", sub)
+	require.Equal(t, expires, exp)
+
+	// the signature covers the path, issue time, expiry and subject
+	_, err = verify(strings.Replace(signed, "latest", "next", 1))
+	require.ErrorIs(t, err, ErrInvalidSignature)
+	_, err = verify(strings.Replace(signed, "sub=cdn", "sub=other", 1))
+	require.ErrorIs(t, err, ErrInvalidSignature)
+	_, err = verify(strings.Replace(signed, "expires=", "expires=1", 1))
+	require.ErrorIs(t, err, ErrInvalidSignature)
+	_, err = verify(strings.Replace(signed, "iat=", "iat=1", 1))
+	require.ErrorIs(t, err, ErrInvalidSignature)
+	_, _, err = Verify([]byte("other"), u, now, 0)
+	require.ErrorIs(t, err, ErrInvalidSignature)
+
+	_, _, err = Verify(secret, u, expires, 0)
+	require.ErrorIs(t, err, ErrExpired)
+	_, _, err = Verify(secret, u, now, time.Minute)
+	require.ErrorIs(t, err, ErrTooLong)
+
+	// the maximum validity bounds how long the URL lives, not how long it remains valid
+	yearLong, err := Sign(secret, "/v2/beacons/quicknet/rounds/latest", now.Add(365*24*time.Hour), WithIssuedAt(now))
+	require.NoError(t, err)
+	u, err = url.Parse(yearLong)
+	require.NoError(t, err)
+	_, _, err = Verify(secret, u, now.Add(365*24*time.Hour-time.Hour), 24*time.Hour)
+	require.ErrorIs(t, err, ErrTooLong)
+	_, _, err = Verify(secret, u, now.Add(365*24*time.Hour-time.Hour), 0)
+	require.NoError(t, err)
+
+	_, err = verify("https://relay.example.com/v2/beacons/quicknet/rounds/latest")
+	require.ErrorIs(t, err, ErrInvalidSignature)
+}
+
+func TestSignPrefix(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+	signed, err := Sign(secret, "/v2/beacons/quicknet/rounds/1?foo=bar", now.Add(time.Hour), WithPrefix("/v2/beacons/quicknet"))
+	require.NoError(t, err)
+
+	for path, valid := range map[string]bool{
+		"/v2/beacons/quicknet/rounds/1":      true,
+		"/v2/beacons/quicknet/rounds/latest": true,
+		"/v2/beacons/quicknet":               true,
+		"/v2/beacons/quicknet-t/info":        false,
+		"/v2/beacons/default/rounds/1":       false,
+	} {
+		u, err := url.Parse(signed)
+		require.NoError(t, err)
+		require.Equal(t, "bar", u.Query().Get("foo"))
+		u.Path = path
+		_, _, err = Verify(secret, u, now, 0)
+		if valid {
+			require.NoError(t, err, path)
+		} else {
+			require.ErrorIs(t, err, ErrInvalidSignature, path)
+		}
+	}
+
+	_, err = Sign(secret, "/v2/beacons/default/rounds/1", now.Add(time.Hour), WithPrefix("/v2/beacons/quicknet"))
+	require.Error(t, err)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	proto "github.com/drand/drand/v2/protobuf/drand"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The tiers used when a request has no JWT, and when its JWT has no known tier claim.
+const (
+	tierAnonymous = "anonymous"
+	tierDefault   = "default"
+)
+
+// tierLimits are the limits of a tier. Requests refill a token bucket of Burst tokens at Rate tokens per second,
+// while long-lived requests are limited to Concurrent requests at a time instead. Zero values mean no limit.
+type tierLimits struct {
+	Rate       float64 `json:"rate"`
+	Burst      int     `json:"burst"`
+	Concurrent int     `json:"concurrent"`
+}
+
+type bucket struct {
+	lim      tierLimits
+	tokens   float64
+	last     time.Time
+	inFlight int
+}
+
+// rateLimiter limits the requests of each identity, that is the JWT subject when authentication is enabled, or the
+// client IP otherwise, according to the limits of its tier.
+type rateLimiter struct {
+	tiers map[string]tierLimits
+	now   func() time.Time
+	// client tells the requests for the round that is not emitted yet, which wait for it like /rounds/next ones
+	client Client
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// sweepInterval is how often we forget the identities that are not limited anymore, to bound our memory usage.
+const sweepInterval = time.Minute
+
+func newRateLimiter(tiers map[string]tierLimits) *rateLimiter {
+	for name, t := range tiers {
+		if t.Burst < 1 {
+			t.Burst = max(1, int(math.Ceil(t.Rate)))
+			tiers[name] = t
+		}
+	}
+	return &rateLimiter{tiers: tiers, now: time.Now, buckets: make(map[string]*bucket)}
+}
+
+// loadRateLimits reads the limits of each tier from a JSON file mapping tier names to their limits.
+func loadRateLimits(path string) (*rateLimiter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tiers := make(map[string]tierLimits)
+	if err := json.Unmarshal(data, &tiers); err != nil {
+		return nil, fmt.Errorf("invalid rate limits file %s: %w", path, err)
+	}
+	for name, t := range tiers {
+		if t.Rate < 0 || t.Burst < 0 || t.Concurrent < 0 {
+			return nil, fmt.Errorf("invalid negative limits for tier %q", name)
+		}
+	}
+	return newRateLimiter(tiers), nil
+}
+
+// identify returns the identity and the tier of the request, or an empty identity if it cannot be limited.
+func (l *rateLimiter) identify(r *http.Request) (string, string) {
+	if claims, ok := claimsFrom(r.Context()); ok && claims.Subject != "" {
+		if _, known := l.tiers[claims.Tier]; known {
+			return "sub:" + claims.Subject, claims.Tier
+		}
+		return "sub:" + claims.Subject, tierDefault
+	}
+
+	if unixPeer(r) {
+		// all the Unix socket peers would share the same bucket
+		return "", ""
+	}
+	ip := clientIP(r)
+	if _, ok := claimsFrom(r.Context()); ok {
+		// tokens without subject are limited using the client IP
+		return "ip:" + ip, tierDefault
+	}
+	return "ip:" + ip, tierAnonymous
+}
+
+// limits returns the limits of the tier, falling back to the default tier, and whether there are any.
+func (l *rateLimiter) limits(tier string) (tierLimits, string, bool) {
+	if t, ok := l.tiers[tier]; ok {
+		return t, tier, true
+	}
+	t, ok := l.tiers[tierDefault]
+	return t, tierDefault, ok
+}
+
+// bucket returns the bucket of the key, it must be called with the lock held.
+func (l *rateLimiter) bucket(key string, lim tierLimits, now time.Time) *bucket {
+	if now.Sub(l.lastSweep) > sweepInterval {
+		for k, b := range l.buckets {
+			if b.inFlight == 0 && (b.lim.Rate == 0 || b.tokens+now.Sub(b.last).Seconds()*b.lim.Rate >= float64(b.lim.Burst)) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{lim: lim, tokens: float64(lim.Burst), last: now}
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// take consumes a token from the bucket of key. It returns whether the request is allowed, the tokens remaining, how
+// long until the bucket is full again, and how long until a token is available when the request is refused.
+func (l *rateLimiter) take(key string, lim tierLimits) (bool, float64, time.Duration, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b := l.bucket(key, lim, now)
+	b.tokens = min(float64(lim.Burst), b.tokens+now.Sub(b.last).Seconds()*lim.Rate)
+	b.last = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	reset := time.Duration((float64(lim.Burst) - b.tokens) / lim.Rate * float64(time.Second))
+	retry := time.Duration(0)
+	if !allowed {
+		retry = time.Duration((1 - b.tokens) / lim.Rate * float64(time.Second))
+	}
+	return allowed, b.tokens, reset, retry
+}
+
+// acquire reserves one of the concurrent requests of key, if any is available.
+func (l *rateLimiter) acquire(key string, lim tierLimits) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key, lim, l.now())
+	if b.inFlight >= lim.Concurrent {
+		return false
+	}
+	b.inFlight++
+	return true
+}
+
+func (l *rateLimiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.buckets[key]; ok {
+		b.inFlight--
+	}
+}
+
+// isLongLived returns whether the request is waiting for the next round, rather than fetching an existing one. Both
+// /rounds/next and the rounds of the V2 API requesting the next round by number wait for it, see getBeacon.
+func (l *rateLimiter) isLongLived(r *http.Request) bool {
+	if strings.HasSuffix(r.URL.Path, "/rounds/next") {
+		return true
+	}
+	if l.client == nil {
+		return false
+	}
+
+	// the request is not routed yet, so we parse /v2/chains/{chainhash}/rounds/{round} and
+	// /v2/beacons/{beaconID}/rounds/{round} ourselves
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) != 6 || parts[1] != "v2" || parts[4] != "rounds" {
+		return false
+	}
+	round, err := strconv.ParseUint(parts[5], 10, 64)
+	if err != nil {
+		return false
+	}
+	var m *proto.Metadata
+	switch parts[2] {
+	case "chains":
+		hash, err := hex.DecodeString(parts[3])
+		if err != nil {
+			return false
+		}
+		m = &proto.Metadata{ChainHash: hash}
+	case "beacons":
+		m = &proto.Metadata{BeaconID: parts[3]}
+	default:
+		return false
+	}
+	// the chain infos are cached by our clients
+	info, err := l.client.GetChainInfo(r.Context(), m)
+	if err != nil {
+		return false
+	}
+	_, next := info.ExpectedNext()
+	return round == next
+}
+
+// seconds rounds a duration up to whole seconds, as expected by the Retry-After and RateLimit-* headers.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, tier := l.identify(r)
+		lim, tier, ok := l.limits(tier)
+		if !ok || id == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := tier + "/" + id
+
+		if l.isLongLived(r) {
+			if lim.Concurrent == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !l.acquire(key, lim) {
+				RateLimitRequests.With(prometheus.Labels{"tier": tier, "result": "limited"}).Inc()
+				slog.Warn("too many concurrent requests", "id", id, "tier", tier, "limit", lim.Concurrent)
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, fmt.Sprintf("Too many concurrent requests, the limit is %d", lim.Concurrent), http.StatusTooManyRequests)
+				return
+			}
+			RateLimitRequests.With(prometheus.Labels{"tier": tier, "result": "allowed"}).Inc()
+			RateLimitInFlight.With(prometheus.Labels{"tier": tier}).Inc()
+			defer func() {
+				l.release(key)
+				RateLimitInFlight.With(prometheus.Labels{"tier": tier}).Dec()
+			}()
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if lim.Rate == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, remaining, reset, retry := l.take(key, lim)
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(lim.Burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(int(remaining)))
+		w.Header().Set("RateLimit-Reset", seconds(reset))
+		if !allowed {
+			RateLimitRequests.With(prometheus.Labels{"tier": tier, "result": "limited"}).Inc()
+			slog.Warn("rate limit exceeded", "id", id, "tier", tier, "rate", lim.Rate, "burst", lim.Burst)
+			w.Header().Set("Retry-After", seconds(retry))
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		RateLimitRequests.With(prometheus.Labels{"tier": tier, "result": "allowed"}).Inc()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RateLimit limits the requests of each JWT subject, or client IP when authentication is disabled, using the tiers
+// set in the --rate-limits file. A JWT selects its tier using its tier claim, unauthenticated requests use the
+// anonymous tier and tiers without limits fall back to the default tier. The client tells which requests wait for the
+// next round, which are limited in concurrency rather than using the token bucket.
+func RateLimit(client Client) func(http.Handler) http.Handler {
+	l, err := loadRateLimits(*rateLimitFile)
+	if err != nil {
+		log.Fatal("unable to load --rate-limits: ", err)
+	}
+	l.client = client
+	return l.middleware
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/drand/drand/v2/common"
+	drandcrypto "github.com/drand/drand/v2/crypto"
+	proto "github.com/drand/drand/v2/protobuf/drand"
+	"github.com/drand/http-relay/local"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func withClaims(r *http.Request, c *scopeClaims) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), claimsCtxKey{}, c))
+}
+
+func TestRateLimiter_TokenBucket(t *testing.T) {
+	l := newRateLimiter(map[string]tierLimits{
+		tierAnonymous: {Rate: 1, Burst: 2},
+		tierDefault:   {Rate: 10, Burst: 20},
+		"partner":     {Rate: 100, Burst: 200},
+	})
+	now := time.Unix(1_700_000_000, 0)
+	l.now = func() time.Time { return now }
+	handler := l.middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	get := func(addr string, c *scopeClaims) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v2/beacons/default/rounds/latest", nil)
+		req.RemoteAddr = addr
+		if c != nil {
+			req = withClaims(req, c)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// anonymous requests are limited per IP
+	require.Equal(t, http.StatusOK, get("1.2.3.4:1000", nil).Code)
+	rr := get("1.2.3.4:1001", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "2", rr.Header().Get("RateLimit-Limit"))
+	require.Equal(t, "0", rr.Header().Get("RateLimit-Remaining"))
+	require.Equal(t, "2", rr.Header().Get("RateLimit-Reset"))
+	rr = get("1.2.3.4:1002", nil)
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+	require.Equal(t, "1", rr.Header().Get("Retry-After"))
+	require.Equal(t, http.StatusOK, get("5.6.7.8:1000", nil).Code)
+
+	// the bucket refills over time
+	now = now.Add(time.Second)
+	require.Equal(t, http.StatusOK, get("1.2.3.4:1003", nil).Code)
+	require.Equal(t, http.StatusTooManyRequests, get("1.2.3.4:1004", nil).Code)
+
+	// authenticated requests are limited per subject, across IPs, using their tier
+	partner := &scopeClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: "a"}, Tier: "partner"}
+	rr = get("1.2.3.4:1005", partner)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "200", rr.Header().Get("RateLimit-Limit"))
+	require.Equal(t, "199", rr.Header().Get("RateLimit-Remaining"))
+
+	// unknown tiers use the default one
+	unknown := &scopeClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: "b"}, Tier: "gold"}
+	rr = get("1.2.3.4:1006", unknown)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "20", rr.Header().Get("RateLimit-Limit"))
+
+	// the subject bucket is shared between IPs
+	for i := 0; i < 19; i++ {
+		require.Equal(t, http.StatusOK, get("9.9.9.9:1000", unknown).Code)
+	}
+	require.Equal(t, http.StatusTooManyRequests, get("8.8.8.8:1000", unknown).Code)
+
+	// the Unix socket peers have no IP to be limited with, but their subjects still are
+	unix := func(c *scopeClaims) int {
+		req := httptest.NewRequest(http.MethodGet, "/v2/beacons/default/rounds/latest", nil)
+		req.RemoteAddr = "@"
+		req = req.WithContext(context.WithValue(req.Context(), unixPeerCtxKey{}, true))
+		if c != nil {
+			req = withClaims(req, c)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	for i := 0; i < 5; i++ {
+		require.Equal(t, http.StatusOK, unix(nil))
+	}
+	require.Equal(t, http.StatusTooManyRequests, unix(unknown))
+}
+
+func TestRateLimiter_Concurrent(t *testing.T) {
+	l := newRateLimiter(map[string]tierLimits{
+		tierDefault: {Rate: 1, Burst: 1, Concurrent: 1},
+	})
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := l.middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	c := &scopeClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: "a"}}
+	get := func() *httptest.ResponseRecorder {
+		req := withClaims(httptest.NewRequest(http.MethodGet, "/v2/beacons/default/rounds/next", nil), c)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	done := make(chan int)
+	go func() { done <- get().Code }()
+	<-started
+
+	// long-polls don't use the token bucket but are limited in concurrency
+	rr := get()
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+	require.Equal(t, "1", rr.Header().Get("Retry-After"))
+
+	close(release)
+	require.Equal(t, http.StatusOK, <-done)
+
+	go func() { <-started }()
+	require.Equal(t, http.StatusOK, get().Code)
+}
+
+func TestRateLimiter_NextRoundByNumber(t *testing.T) {
+	dev, err := local.NewDevChain(drandcrypto.SigsOnG1ID, time.Minute, common.DefaultBeaconID)
+	require.NoError(t, err)
+	defer dev.Close()
+	info, err := dev.GetChainInfo(context.Background(), &proto.Metadata{BeaconID: common.DefaultBeaconID})
+	require.NoError(t, err)
+	_, next := info.ExpectedNext()
+
+	l := newRateLimiter(map[string]tierLimits{
+		tierDefault: {Rate: 1, Burst: 1, Concurrent: 1},
+	})
+	l.client = dev
+	c := &scopeClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: "a"}}
+	for path, longLived := range map[string]bool{
+		"/v2/beacons/default/rounds/next":                                              true,
+		"/v2/beacons/default/rounds/" + strconv.FormatUint(next, 10):                   true,
+		"/v2/chains/" + info.Hash.String() + "/rounds/" + strconv.FormatUint(next, 10): true,
+		"/v2/beacons/default/rounds/" + strconv.FormatUint(next-1, 10):                 false,
+		"/v2/beacons/default/rounds/latest":                                            false,
+		"/v2/beacons/unknown/rounds/" + strconv.FormatUint(next, 10):                   false,
+		"/v2/chains/nothex/rounds/" + strconv.FormatUint(next, 10):                     false,
+		"/public/" + strconv.FormatUint(next, 10):                                      false,
+	} {
+		require.Equal(t, longLived, l.isLongLived(withClaims(httptest.NewRequest(http.MethodGet, path, nil), c)), path)
+	}
+}
+
+func TestLoadRateLimits(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "limits.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"default": {"rate": 2.5}, "anonymous": {"rate": 1, "burst": 10}}`), 0o600))
+	l, err := loadRateLimits(path)
+	require.NoError(t, err)
+	// the burst defaults to the rate
+	require.Equal(t, tierLimits{Rate: 2.5, Burst: 3}, l.tiers[tierDefault])
+	require.Equal(t, tierLimits{Rate: 1, Burst: 10}, l.tiers[tierAnonymous])
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"default": {"rate": -1}}`), 0o600))
+	_, err = loadRateLimits(path)
+	require.Error(t, err)
+}
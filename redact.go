@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are the log attributes, e.g. headers, whose value is always redacted.
+var sensitiveKeys = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"set-cookie":          true,
+	"x-api-key":           true,
+}
+
+type redactRule struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// redactor masks the credentials found in logs: JWTs, Authorization header values, API keys, presigned URL signatures,
+// HMAC secrets and the patterns set using --log-redact.
+type redactor struct {
+	rules []redactRule
+}
+
+// logRedactor is redacting every log sink: the default slog logger, also used by the grpc Client, and the httplog
+// request logger.
+var logRedactor = newRedactor()
+
+func newRedactor() *redactor {
+	return &redactor{rules: []redactRule{
+		{regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]+`), "$1 " + redacted},
+		{regexp.MustCompile(`\beyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), redacted},
+		// we keep the ID of API keys, which isn't secret and helps with debugging
+		{regexp.MustCompile(`\b([0-9a-f]{16})\.[0-9a-f]{64}\b`), "$1." + redacted},
+		{regexp.MustCompile(`([?&]signature=)[^&\s"]+`), "${1}" + redacted},
+		{regexp.MustCompile(`\b[0-9a-fA-F]{256,}\b`), redacted},
+	}}
+}
+
+// add redacts the matches of the provided comma-separated regular expressions as well.
+func (r *redactor) add(patterns string) error {
+	for _, p := range strings.Split(patterns, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("invalid redaction pattern %q: %w", p, err)
+		}
+		r.rules = append(r.rules, redactRule{re: re, repl: redacted})
+	}
+	return nil
+}
+
+func (r *redactor) string(s string) string {
+	for _, rule := range r.rules {
+		s = rule.re.ReplaceAllString(s, rule.repl)
+	}
+	return s
+}
+
+// replaceAttr is a slog.HandlerOptions ReplaceAttr function redacting the attributes, including the messages.
+func (r *redactor) replaceAttr(_ []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redacted)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(r.string(a.Value.String()))
+	case slog.KindAny:
+		// errors and other values are only replaced by their string representation when they need redacting
+		s := fmt.Sprintf("%+v", a.Value.Any())
+		if rs := r.string(s); rs != s {
+			a.Value = slog.StringValue(rs)
+		}
+	}
+	return a
+}
+
+// attr redacts the attribute, including the attributes of groups.
+func (r *redactor) attr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() != slog.KindGroup {
+		return r.replaceAttr(nil, a)
+	}
+	attrs := a.Value.Group()
+	redactedAttrs := make([]slog.Attr, len(attrs))
+	for i, ga := range attrs {
+		redactedAttrs[i] = r.attr(ga)
+	}
+	return slog.Attr{Key: a.Key, Value: slog.GroupValue(redactedAttrs...)}
+}
+
+// redactHandler redacts the records of the handlers that only apply ReplaceAttr to some attributes, like the
+// httplog pretty handler.
+type redactHandler struct {
+	slog.Handler
+	r *redactor
+}
+
+func (h *redactHandler) Handle(ctx context.Context, rec slog.Record) error {
+	out := slog.NewRecord(rec.Time, rec.Level, h.r.string(rec.Message), rec.PC)
+	rec.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.r.attr(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, out)
+}
+
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redactedAttrs := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redactedAttrs[i] = h.r.attr(a)
+	}
+	return &redactHandler{Handler: h.Handler.WithAttrs(redactedAttrs), r: h.r}
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{Handler: h.Handler.WithGroup(name), r: h.r}
+}
+
+// newLogHandler returns the redacting handler of the default slog logger, writing text or JSON logs to w.
+func newLogHandler(w io.Writer) slog.Handler {
+	opts := &slog.HandlerOptions{Level: getLogLevel(), ReplaceAttr: logRedactor.replaceAttr}
+	if *jsonFlag {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
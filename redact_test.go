@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/httplog/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactor(t *testing.T) {
+	token, err := jwt.New(jwt.SigningMethodHS256).SignedString([]byte("secret"))
+	require.NoError(t, err)
+	apiKey := strings.Repeat("a", 16) + "." + strings.Repeat("b", 64)
+	secret := strings.Repeat("c", 256)
+
+	r := newRedactor()
+	require.NoError(t, r.add(`partner-[0-9]+, ,`))
+	require.Error(t, r.add(`(`))
+
+	for in, out := range map[string]string{
+		"Bearer " + token:                             "Bearer " + redacted,
+		"basic dXNlcjpwYXNz":                          "basic " + redacted,
+		"token " + token + " refused":                 "token " + redacted + " refused",
+		"key " + apiKey:                               "key " + strings.Repeat("a", 16) + "." + redacted,
+		"/v2/chains?expires=1&signature=abcd&sub=a":   "/v2/chains?expires=1&signature=" + redacted + "&sub=a",
+		"DRAND_AUTH_KEY=" + secret:                    "DRAND_AUTH_KEY=" + redacted,
+		"customer partner-42 failed":                  "customer " + redacted + " failed",
+		"round 1234 of 52db9ba70e0cc0f6eaf7803dd0744": "round 1234 of 52db9ba70e0cc0f6eaf7803dd0744",
+	} {
+		require.Equal(t, out, r.string(in))
+	}
+}
+
+func TestRedactingLoggers(t *testing.T) {
+	token, err := jwt.New(jwt.SigningMethodHS256).SignedString([]byte("secret"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	for _, json := range []bool{false, true} {
+		setFlag(t, jsonFlag, json)
+		l := slog.New(newLogHandler(&buf))
+		l.Error("refused "+token, "err", errors.New("bad token "+token), "Authorization", "Bearer "+token,
+			slog.Group("header", "X-Api-Key", "some-key", "Accept", "application/json"))
+		l.With("token", token).Info("with attrs")
+	}
+
+	// the request logger of drandHandler, in both formats
+	for _, json := range []bool{false, true} {
+		logger := httplog.NewLogger("test", httplog.Options{JSON: json, Writer: &buf})
+		logger.Logger = slog.New(&redactHandler{Handler: logger.Logger.Handler(), r: logRedactor})
+		logger.With(slog.Group("httpRequest", "url", "/v2/chains?signature=abcd")).
+			Info("request "+token, slog.Group("httpResponse", slog.Group("header", "Set-Cookie", "session")))
+	}
+
+	logs := buf.String()
+	require.NotContains(t, logs, token)
+	require.NotContains(t, logs, "some-key")
+	require.NotContains(t, logs, "abcd")
+	require.NotContains(t, logs, "session")
+	require.Contains(t, logs, "application/json")
+	require.Contains(t, logs, redacted)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// revocationList holds the revoked JWT IDs listed in a file, one per line, lines starting with # being comments.
+// The file is reloaded when it changes, so that tokens can be revoked without restarting the relay.
+type revocationList struct {
+	path string
+
+	mu      sync.RWMutex
+	ids     map[string]struct{}
+	modTime time.Time
+	size    int64
+}
+
+// loadRevocationList loads the list of revoked JWT IDs from the provided file.
+func loadRevocationList(path string) (*revocationList, error) {
+	l := &revocationList{path: path}
+	if _, err := l.reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// reload reads the file again if it changed since the last time it was loaded, and returns whether it did.
+func (l *revocationList) reload() (bool, error) {
+	st, err := os.Stat(l.path)
+	if err != nil {
+		return false, fmt.Errorf("unable to read revocation list: %w", err)
+	}
+
+	l.mu.RLock()
+	unchanged := st.ModTime().Equal(l.modTime) && st.Size() == l.size
+	l.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return false, fmt.Errorf("unable to read revocation list: %w", err)
+	}
+	ids := make(map[string]struct{})
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ids[line] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("invalid revocation list: %w", err)
+	}
+
+	l.mu.Lock()
+	l.ids, l.modTime, l.size = ids, st.ModTime(), st.Size()
+	l.mu.Unlock()
+	return true, nil
+}
+
+// watch checks the file for changes every interval until the context is cancelled, keeping the current list if it
+// cannot be reloaded.
+func (l *revocationList) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := l.reload()
+			if err != nil {
+				slog.Error("unable to reload JWT revocation list, keeping the current one", "err", err)
+			} else if reloaded {
+				slog.Info("reloaded JWT revocation list", "path", l.path, "revoked", l.len())
+			}
+		}
+	}
+}
+
+// revoked returns whether the provided JWT ID is revoked.
+func (l *revocationList) revoked(jti string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.ids[jti]
+	return ok
+}
+
+func (l *revocationList) len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.ids)
+}
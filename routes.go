@@ -1,13 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"log/slog"
 	"net/http"
 	"slices"
 	"strings"
 
-	"github.com/drand/http-relay/grpc"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -60,55 +61,99 @@ func DisplayRoutes(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(strings.Join(filteredRoutes, "\n")))
 }
 
-func SetupRoutes(r *chi.Mux, client *grpc.Client) {
+func SetupRoutes(ctx context.Context, r *chi.Mux, client Client) {
 	// Catch-all route for any other GET request, we display routes instead
 	// we need to declare that before setup to avoid the r.Group to match first
 	r.NotFound(DisplayRoutes)
 
 	r.Get("/public/18446744073709551615", sendMaxInt())
 
+	// JWT and API key authentication, both to be issued using the jwtissuer binary, shared by the route groups
+	var auth func(http.Handler) http.Handler
+	if *requireAuth || *apiKeysFile != "" {
+		auth = newAuth(ctx)
+	}
+	if *v1Auth && auth == nil {
+		log.Fatal("--v1-auth requires --enable-auth or --auth-api-keys")
+	}
+
+	// only the grpc client has multiple backends to query, quorum reads are set after authentication and rate limiting
+	backends := 0
+	if b, ok := client.(interface{ Backends() int }); ok {
+		backends = b.Backends()
+	}
+
 	// v2 routes with optional ACL using JWT
 	r.Group(func(r chi.Router) {
-		// JWT authentication, tokens to be issued using the jwtissuer binary
-		if *requireAuth {
-			r.Use(AddAuth)
+		if *v2Allow != "" {
+			r.Use(allowIPs("--v2-allow", *v2Allow))
+		}
+		if auth != nil {
+			r.Use(auth)
 		}
 		r.Route("/v2", func(r chi.Router) {
 			// use our common headers for the following routes
 			r.Use(addCommonHeaders)
+			// rate limiting relies on the JWT subject and tier when authentication is enabled
+			if *rateLimitFile != "" {
+				r.Use(RateLimit(client))
+			}
+			if backends > 0 {
+				r.Use(quorumReads(backends, *rateLimitFile != ""))
+			}
 			r.Get("/chains", GetChains(client))
 
-			r.Get("/chains/{chainhash:[0-9A-Fa-f]{64}}/info", GetInfoV2(client))
-			r.Get("/chains/{chainhash:[0-9A-Fa-f]{64}}/health", GetHealth(client))
-			r.Get("/chains/{chainhash:[0-9A-Fa-f]{64}}/rounds/{round:\\d+}", GetBeacon(client, true))
-			r.Get("/chains/{chainhash:[0-9A-Fa-f]{64}}/rounds/latest", GetLatest(client, true))
-			r.Get("/chains/{chainhash:[0-9A-Fa-f]{64}}/rounds/next", GetNext(client, true))
+			// the scopes of the JWTs are enforced once routed, when we know which chain is requested
+			chain := requireScope(client, "")
+			rounds := requireScope(client, opRounds)
+			latest := requireScope(client, opLatest)
+			next := requireScope(client, opNext)
+
+			r.With(chain).Get("/chains/{chainhash:[0-9A-Fa-f]{64}}/info", GetInfoV2(client))
+			r.With(chain).Get("/chains/{chainhash:[0-9A-Fa-f]{64}}/health", GetHealth(client))
+			r.With(rounds).Get("/chains/{chainhash:[0-9A-Fa-f]{64}}/rounds/{round:\\d+}", GetBeacon(client, true))
+			r.With(latest).Get("/chains/{chainhash:[0-9A-Fa-f]{64}}/rounds/latest", GetLatest(client, true))
+			r.With(next).Get("/chains/{chainhash:[0-9A-Fa-f]{64}}/rounds/next", GetNext(client, true))
 
 			r.Get("/beacons", GetBeaconIds(client))
-			r.Get("/beacons/{beaconID}/info", GetInfoV2(client))
-			r.Get("/beacons/{beaconID}/health", GetHealth(client))
-			r.Get("/beacons/{beaconID}/rounds/{round:\\d+}", GetBeacon(client, true))
-			r.Get("/beacons/{beaconID}/rounds/latest", GetLatest(client, true))
-			r.Get("/beacons/{beaconID}/rounds/next", GetNext(client, true))
+			r.With(chain).Get("/beacons/{beaconID}/info", GetInfoV2(client))
+			r.With(chain).Get("/beacons/{beaconID}/health", GetHealth(client))
+			r.With(rounds).Get("/beacons/{beaconID}/rounds/{round:\\d+}", GetBeacon(client, true))
+			r.With(latest).Get("/beacons/{beaconID}/rounds/latest", GetLatest(client, true))
+			r.With(next).Get("/beacons/{beaconID}/rounds/next", GetNext(client, true))
 		})
 	})
 
-	// v1 API CANNOT BE CHANGED until deprecation
+	// v1 API CANNOT BE CHANGED until deprecation, but private deployments can restrict its access
 	r.Group(func(r chi.Router) {
+		if *v1Allow != "" {
+			r.Use(allowIPs("--v1-allow", *v1Allow))
+		}
+		if *v1Auth {
+			r.Use(auth)
+		}
 		// use our common headers for the following routes
 		r.Use(addCommonHeaders)
+		if backends > 0 {
+			r.Use(quorumReads(backends, false))
+		}
 
 		r.Get("/chains", GetChains(client))
 
-		r.Get("/info", GetInfoV1(client))
-		r.Get("/health", GetHealth(client))
-		r.Get("/public/{round:\\d+}", GetBeacon(client, false))
-		r.Get("/public/latest", GetLatest(client, false))
+		// the scopes of the JWTs are only enforced with --v1-auth, requireScope letting everything through otherwise
+		chain := requireScope(client, "")
+		rounds := requireScope(client, opRounds)
+		latest := requireScope(client, opLatest)
+
+		r.With(chain).Get("/info", GetInfoV1(client))
+		r.With(chain).Get("/health", GetHealth(client))
+		r.With(rounds).Get("/public/{round:\\d+}", GetBeacon(client, false))
+		r.With(latest).Get("/public/latest", GetLatest(client, false))
 
-		r.Get("/{chainhash:[0-9A-Fa-f]{64}}/info", GetInfoV1(client))
-		r.Get("/{chainhash:[0-9A-Fa-f]{64}}/health", GetHealth(client))
-		r.Get("/{chainhash:[0-9A-Fa-f]{64}}/public/{round:\\d+}", GetBeacon(client, false))
-		r.Get("/{chainhash:[0-9A-Fa-f]{64}}/public/latest", GetLatest(client, false))
+		r.With(chain).Get("/{chainhash:[0-9A-Fa-f]{64}}/info", GetInfoV1(client))
+		r.With(chain).Get("/{chainhash:[0-9A-Fa-f]{64}}/health", GetHealth(client))
+		r.With(rounds).Get("/{chainhash:[0-9A-Fa-f]{64}}/public/{round:\\d+}", GetBeacon(client, false))
+		r.With(latest).Get("/{chainhash:[0-9A-Fa-f]{64}}/public/latest", GetLatest(client, false))
 	})
 
 	// we want to populate all the routes served by our Chi router to display them in DisplayRoutes
@@ -9,7 +9,6 @@ import (
 	"log/slog"
 	"net/http"
 	"strconv"
-	"time"
 
 	"github.com/drand/drand/v2/common"
 	proto "github.com/drand/drand/v2/protobuf/drand"
@@ -17,7 +16,7 @@ import (
 	"github.com/go-chi/chi/v5"
 )
 
-func GetBeacon(c *grpc.Client, isV2 bool) func(http.ResponseWriter, *http.Request) {
+func GetBeacon(c Client, isV2 bool) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		roundStr := chi.URLParam(r, "round")
 		round, err := strconv.ParseUint(roundStr, 10, 64)
@@ -33,7 +32,9 @@ func GetBeacon(c *grpc.Client, isV2 bool) func(http.ResponseWriter, *http.Reques
 		if err != nil {
 			slog.Error("Failed get beacon", "error", err, "nextTime", nextTime)
 
-			if nextTime < 0 {
+			if errors.Is(err, errDraining) {
+				writeDraining(w)
+			} else if nextTime < 0 {
 				w.Header().Set("Cache-Control", fmt.Sprintf("must-revalidate, public, max-age=%d", -nextTime))
 
 				// I know, 425 is meant to indicate a replay attack risk, but hey, it's the perfect error name!
@@ -53,7 +54,7 @@ func GetBeacon(c *grpc.Client, isV2 bool) func(http.ResponseWriter, *http.Reques
 // getBeacon return the HexBeacon, the time of the next round, and/or an error.
 // A negative nextTime value is only used in case of an error, to indicate how
 // long that error should be cached.
-func getBeacon(c *grpc.Client, r *http.Request, round uint64) (*grpc.HexBeacon, int64, error) {
+func getBeacon(c Client, r *http.Request, round uint64) (*grpc.HexBeacon, int64, error) {
 	m, err := createRequestMD(r)
 	if err != nil {
 		return nil, 0, fmt.Errorf("createRequestMD error: %w", err)
@@ -76,7 +77,7 @@ func getBeacon(c *grpc.Client, r *http.Request, round uint64) (*grpc.HexBeacon,
 	var beacon *grpc.HexBeacon
 	// if we are requesting the next round
 	if round == nextRound {
-		beacon, err = c.Next(r.Context(), m)
+		beacon, err = waitNext(c, r, m)
 		if err != nil {
 			slog.Error("[GetBeacon] unable to get next beacon from any grpc client", "error", err)
 			return nil, 0, fmt.Errorf("Next error: %w", err)
@@ -132,9 +133,11 @@ func writeBeacon(w http.ResponseWriter, beacon *grpc.HexBeacon, nextTime int64,
 	} else if nextTime < 0 {
 		// we must never cache the next beacon, since we wait for them
 		w.Header().Set("Cache-Control", "no-cache")
+		// the next beacon is streamed by a single backend, it is never a quorum read
+		w.Header().Del(quorumHeader)
 	} else {
 		// for latest we compute the right time
-		cacheTime := nextTime - time.Now().Unix()
+		cacheTime := nextTime - grpc.Now().Unix()
 		if cacheTime < 0 {
 			cacheTime = 0
 		}
@@ -148,7 +151,7 @@ func writeBeacon(w http.ResponseWriter, beacon *grpc.HexBeacon, nextTime int64,
 	w.Write(json)
 }
 
-func GetLatest(c *grpc.Client, isV2 bool) func(http.ResponseWriter, *http.Request) {
+func GetLatest(c Client, isV2 bool) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		beacon, nextTime, err := getBeacon(c, r, 0)
 		if err != nil {
@@ -163,7 +166,7 @@ func GetLatest(c *grpc.Client, isV2 bool) func(http.ResponseWriter, *http.Reques
 	}
 }
 
-func GetNext(c *grpc.Client, isV2 bool) func(http.ResponseWriter, *http.Request) {
+func GetNext(c Client, isV2 bool) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		m, err := createRequestMD(r)
 		if err != nil {
@@ -172,8 +175,12 @@ func GetNext(c *grpc.Client, isV2 bool) func(http.ResponseWriter, *http.Request)
 			return
 		}
 
-		beacon, err := c.Next(r.Context(), m)
-		if err != nil {
+		beacon, err := waitNext(c, r, m)
+		if errors.Is(err, errDraining) {
+			slog.Debug("[GetNext] telling client to retry while shutting down")
+			writeDraining(w)
+			return
+		} else if err != nil {
 			slog.Error("[GetNext] unable to get next beacon from any grpc client", "error", err)
 			http.Error(w, "Failed to get beacon", http.StatusInternalServerError)
 			return
@@ -184,7 +191,7 @@ func GetNext(c *grpc.Client, isV2 bool) func(http.ResponseWriter, *http.Request)
 	}
 }
 
-func GetChains(c *grpc.Client) func(http.ResponseWriter, *http.Request) {
+func GetChains(c Client) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		chains, err := c.GetChains(r.Context())
 		if err != nil {
@@ -204,7 +211,7 @@ func GetChains(c *grpc.Client) func(http.ResponseWriter, *http.Request) {
 	}
 }
 
-func GetHealth(c *grpc.Client) func(http.ResponseWriter, *http.Request) {
+func GetHealth(c Client) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// we never cache health requests (rate-limiting should prevent DoS at the proxy level)
 		w.Header().Set("Cache-Control", "no-cache")
@@ -265,7 +272,7 @@ func GetHealth(c *grpc.Client) func(http.ResponseWriter, *http.Request) {
 	}
 }
 
-func GetBeaconIds(c *grpc.Client) func(http.ResponseWriter, *http.Request) {
+func GetBeaconIds(c Client) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ids, _, err := c.GetBeaconIds(r.Context())
 		if err != nil {
@@ -284,7 +291,7 @@ func GetBeaconIds(c *grpc.Client) func(http.ResponseWriter, *http.Request) {
 	}
 }
 
-func GetInfoV1(c *grpc.Client) func(http.ResponseWriter, *http.Request) {
+func GetInfoV1(c Client) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		m, err := createRequestMD(r)
 		if err != nil {
@@ -311,7 +318,7 @@ func GetInfoV1(c *grpc.Client) func(http.ResponseWriter, *http.Request) {
 	}
 }
 
-func GetInfoV2(c *grpc.Client) func(http.ResponseWriter, *http.Request) {
+func GetInfoV2(c Client) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		m, err := createRequestMD(r)
 		if err != nil {
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/drand/drand/v2/common"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// The operations that can be granted to a JWT using its ops claim.
+const (
+	opLatest = "latest"
+	opNext   = "next"
+	opRounds = "rounds"
+)
+
+// scopeClaims are the claims of our JWTs, the optional chains, beacon_ids and ops claims restrict what a token grants
+// access to. A missing or null claim doesn't restrict anything, to remain compatible with the tokens issued without
+// claims, while an empty list grants nothing, hence the lack of omitempty. The tier claim selects the rate limits.
+type scopeClaims struct {
+	jwt.RegisteredClaims
+	Chains    []string `json:"chains"`
+	BeaconIDs []string `json:"beacon_ids"`
+	Ops       []string `json:"ops"`
+	Tier      string   `json:"tier,omitempty"`
+}
+
+type claimsCtxKey struct{}
+
+// claimsFrom returns the claims of the JWT authenticating the request, if any.
+func claimsFrom(ctx context.Context) (*scopeClaims, bool) {
+	claims, ok := ctx.Value(claimsCtxKey{}).(*scopeClaims)
+	return claims, ok
+}
+
+// allowsOp returns whether the claims grant the provided operation, an empty op being always allowed.
+func (s *scopeClaims) allowsOp(op string) bool {
+	return op == "" || s.Ops == nil || slices.Contains(s.Ops, op)
+}
+
+// allowsChain returns whether the claims grant access to the chain with the provided hash and beacon ID, either
+// through its chain hash or its beacon ID.
+func (s *scopeClaims) allowsChain(hash, beaconID string) bool {
+	if s.Chains == nil && s.BeaconIDs == nil {
+		return true
+	}
+	return slices.ContainsFunc(s.Chains, func(c string) bool {
+		return strings.EqualFold(c, hash)
+	}) || slices.ContainsFunc(s.BeaconIDs, func(id string) bool {
+		return common.CompareBeaconIDs(id, beaconID)
+	})
+}
+
+// requireScope makes sure that the JWT authenticating the request, if any, grants access to the requested chain and
+// to the provided operation, replying with a 403 otherwise. It must be used on the routes themselves, since the chi
+// route params are only available once routed.
+func requireScope(c Client, op string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := claimsFrom(r.Context())
+			if !ok {
+				// authentication is disabled
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !claims.allowsOp(op) {
+				slog.Error("JWT not allowed to access operation", "op", op, "sub", claims.Subject, "uri", r.RequestURI)
+				http.Error(w, fmt.Sprintf("Forbidden: token does not grant the %q operation", op), http.StatusForbidden)
+				return
+			}
+
+			if claims.Chains != nil || claims.BeaconIDs != nil {
+				m, err := createRequestMD(r)
+				if err != nil {
+					http.Error(w, "Failed to parse chain", http.StatusBadRequest)
+					return
+				}
+				// we need the chain info to match chain hashes with beacon IDs, it is cached by our clients
+				info, err := c.GetChainInfo(r.Context(), m)
+				if err != nil {
+					// we cannot tell whether the token grants access, but it is not an authorization failure
+					slog.Error("unable to get chain info to check the JWT scopes", "chain", m.String(), "sub", claims.Subject, "uri", r.RequestURI, "err", err)
+					w.Header().Set("Cache-Control", "no-cache")
+					http.Error(w, "Failed to get chain info", http.StatusServiceUnavailable)
+					return
+				}
+				if !claims.allowsChain(info.Hash.String(), info.BeaconId) {
+					slog.Error("JWT not allowed to access chain", "chain", m.String(), "sub", claims.Subject, "uri", r.RequestURI)
+					requested := chi.URLParam(r, "chainhash") + chi.URLParam(r, "beaconID")
+					http.Error(w, fmt.Sprintf("Forbidden: token does not grant access to chain %q", requested), http.StatusForbidden)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often we check whether the TLS certificate files changed.
+const certCheckInterval = 10 * time.Second
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// certReloader serves a certificate and key pair, loading them again when their files change, so that renewed
+// certificates are served without restarting.
+type certReloader struct {
+	certPath, keyPath string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime [2]time.Time
+}
+
+// newCertReloader loads the certificate and key pair of the provided PEM files.
+func newCertReloader(certPath, keyPath string) (*certReloader, error) {
+	c := &certReloader{certPath: certPath, keyPath: keyPath}
+	if _, err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload loads the certificate files again if any of them changed since the last time they were loaded, and returns
+// whether it did. It keeps the current certificate if the files cannot be read or are invalid, e.g. if only one of
+// them was renewed yet.
+func (c *certReloader) Reload() (bool, error) {
+	var modTime [2]time.Time
+	for i, path := range []string{c.certPath, c.keyPath} {
+		st, err := os.Stat(path)
+		if err != nil {
+			return false, fmt.Errorf("unable to read TLS certificate: %w", err)
+		}
+		modTime[i] = st.ModTime()
+	}
+
+	c.mu.RLock()
+	unchanged := modTime == c.modTime
+	c.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+	if err != nil {
+		return false, fmt.Errorf("unable to load TLS certificate: %w", err)
+	}
+
+	c.mu.Lock()
+	c.cert, c.modTime = &cert, modTime
+	c.mu.Unlock()
+	return true, nil
+}
+
+// Watch checks the files for changes every interval until the context is cancelled, logging failures.
+func (c *certReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := c.Reload()
+			if err != nil {
+				slog.Error("unable to reload TLS certificate, keeping the current one", "err", err)
+			} else if reloaded {
+				slog.Info("reloaded TLS certificate", "cert", c.certPath)
+			}
+		}
+	}
+}
+
+// GetCertificate is a tls.Config GetCertificate function serving the current certificate.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// loadCertPool returns the pool of the CA certificates of the provided PEM file.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA certificates: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificate found in %s", path)
+	}
+	return pool, nil
+}
+
+// parseCipherSuites returns the IDs of the comma-separated cipher suites names, refusing the insecure ones.
+func parseCipherSuites(names string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+	var ids []uint16
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// serverTLSConfig returns the TLS config of the relay server, serving the --tls-cert and requiring client certificates
+// signed by the --tls-client-ca when it is set. The certificate is reloaded when it changes until the context is
+// cancelled.
+func serverTLSConfig(ctx context.Context) (*tls.Config, error) {
+	if *tlsKey == "" {
+		return nil, fmt.Errorf("--tls-cert requires --tls-key")
+	}
+	minVersion, ok := tlsVersions[*tlsMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("invalid --tls-min-version %q, it must be one of 1.0, 1.1, 1.2 or 1.3", *tlsMinVersion)
+	}
+	ciphers, err := parseCipherSuites(*tlsCiphers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --tls-ciphers: %w", err)
+	}
+
+	certs, err := newCertReloader(*tlsCert, *tlsKey)
+	if err != nil {
+		return nil, err
+	}
+	go certs.Watch(ctx, certCheckInterval)
+
+	//nolint:gosec // the minimum version is up to the operator, it defaults to TLS 1.2
+	cfg := &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   ciphers,
+		GetCertificate: certs.GetCertificate,
+	}
+
+	if *tlsClientCA == "" {
+		return cfg, nil
+	}
+	if cfg.ClientCAs, err = loadCertPool(*tlsClientCA); err != nil {
+		return nil, fmt.Errorf("invalid --tls-client-ca: %w", err)
+	}
+	switch *tlsClientAuth {
+	case "require":
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf("invalid --tls-client-auth %q, it must be require or optional", *tlsClientAuth)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert writes a certificate for name, signed by parent or self-signed, and its key to dir.
+func writeCert(t *testing.T, dir, name string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (certPath, keyPath string, cert *x509.Certificate, key *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err = x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPath = filepath.Join(dir, name+".pem")
+	keyPath = filepath.Join(dir, name+"-key.pem")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certPath, keyPath, cert, key
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath, first, _ := writeCert(t, dir, "relay", false, nil, nil)
+
+	c, err := newCertReloader(certPath, keyPath)
+	require.NoError(t, err)
+	reloaded, err := c.Reload()
+	require.NoError(t, err)
+	require.False(t, reloaded)
+
+	// a renewed certificate is served once both files changed
+	renewedDir := t.TempDir()
+	renewedCert, renewedKey, renewed, _ := writeCert(t, renewedDir, "relay", false, nil, nil)
+	data, err := os.ReadFile(renewedCert)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certPath, data, 0o600))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certPath, later, later))
+	_, err = c.Reload()
+	require.Error(t, err, "the new certificate doesn't match the old key")
+	served, err := c.GetCertificate(nil)
+	require.NoError(t, err)
+	require.Equal(t, first.Raw, served.Certificate[0])
+
+	data, err = os.ReadFile(renewedKey)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyPath, data, 0o600))
+	require.NoError(t, os.Chtimes(keyPath, later, later))
+	reloaded, err = c.Reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	served, err = c.GetCertificate(nil)
+	require.NoError(t, err)
+	require.Equal(t, renewed.Raw, served.Certificate[0])
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	ids, err := parseCipherSuites("TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384")
+	require.NoError(t, err)
+	require.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, ids)
+
+	ids, err = parseCipherSuites("")
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	_, err = parseCipherSuites("TLS_RSA_WITH_RC4_128_SHA")
+	require.Error(t, err)
+}
+
+func TestServerTLSConfig_MTLS(t *testing.T) {
+	dir := t.TempDir()
+	caPath, _, ca, caKey := writeCert(t, dir, "ca", true, nil, nil)
+	certPath, keyPath, _, _ := writeCert(t, dir, "relay", false, ca, caKey)
+	partnerCert, partnerKey, _, _ := writeCert(t, dir, "partner", false, ca, caKey)
+	strangerCert, strangerKey, _, _ := writeCert(t, dir, "stranger", false, nil, nil)
+
+	setFlag(t, tlsCert, certPath)
+	setFlag(t, tlsKey, keyPath)
+	setFlag(t, tlsMinVersion, "1.4")
+	_, err := serverTLSConfig(context.Background())
+	require.Error(t, err)
+	setFlag(t, tlsMinVersion, "1.3")
+	setFlag(t, tlsClientCA, caPath)
+	setFlag(t, tlsClientAuth, "require")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg, err := serverTLSConfig(ctx)
+	require.NoError(t, err)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	srv.TLS = cfg
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	get := func(certPath, keyPath string) (*http.Response, error) {
+		tlsCfg := &tls.Config{RootCAs: roots, ServerName: "relay"}
+		if certPath != "" {
+			cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+			require.NoError(t, err)
+			tlsCfg.Certificates = []tls.Certificate{cert}
+		}
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsCfg}}
+		return c.Get(srv.URL)
+	}
+
+	resp, err := get(partnerCert, partnerKey)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, uint16(tls.VersionTLS13), resp.TLS.Version)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "partner", string(body))
+
+	_, err = get(strangerCert, strangerKey)
+	require.Error(t, err)
+	_, err = get("", "")
+	require.Error(t, err)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// listenersEnv is the env variable listing the names of the listeners inherited from the relay we are upgrading,
+	// passed as file descriptors starting at listenFdsStart.
+	listenersEnv = "DRAND_RELAY_LISTENERS"
+	// readyFdEnv is the env variable of the file descriptor on which we tell the relay we are upgrading that we are
+	// serving.
+	readyFdEnv = "DRAND_RELAY_READY_FD"
+	// upgradeTimeout is how long the upgraded relay has to start serving before we give up on it.
+	upgradeTimeout = 30 * time.Second
+	// handoffGrace is how long the connections we accepted right before handing off have to send their request,
+	// since http.Server.Shutdown drops the connections whose request wasn't read yet.
+	handoffGrace = 500 * time.Millisecond
+)
+
+// The names of the listeners passed on upgrades.
+const (
+	bindListener    = "bind"
+	metricsListener = "metrics"
+)
+
+// inheritedListeners returns the listeners inherited from the relay we are upgrading, by name, if any.
+var inheritedListeners = sync.OnceValues(func() (map[string][]net.Listener, error) {
+	names := os.Getenv(listenersEnv)
+	if names == "" {
+		return nil, nil
+	}
+	// the listeners must not be passed to our own children, but on our own upgrades
+	os.Unsetenv(listenersEnv)
+
+	listeners := make(map[string][]net.Listener)
+	for i, name := range strings.Split(names, ",") {
+		fd := listenFdsStart + i
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		// FileListener dups the file descriptor
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s listener inherited on fd %d: %w", name, fd, err)
+		}
+		if ul, ok := l.(*net.UnixListener); ok {
+			// we own the socket file now, until our own upgrade
+			ul.SetUnlinkOnClose(true)
+		}
+		listeners[name] = append(listeners[name], l)
+	}
+	return listeners, nil
+})
+
+// inherited returns the listeners of the provided name inherited from the relay we are upgrading, if any.
+func inherited(name string) ([]net.Listener, error) {
+	listeners, err := inheritedListeners()
+	if err != nil {
+		return nil, err
+	}
+	return listeners[name], nil
+}
+
+// listenMetrics returns the listener of the metrics server, inherited from the relay we are upgrading if any.
+func listenMetrics() (net.Listener, error) {
+	ls, err := inherited(metricsListener)
+	if err != nil {
+		return nil, err
+	}
+	if len(ls) > 0 {
+		return ls[0], nil
+	}
+	return net.Listen("tcp", *metricFlag)
+}
+
+// signalReady tells the relay we are upgrading that we are serving, so that it can stop accepting connections.
+func signalReady() {
+	fdStr := os.Getenv(readyFdEnv)
+	if fdStr == "" {
+		return
+	}
+	os.Unsetenv(readyFdEnv)
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		slog.Error("invalid upgrade ready file descriptor", "env", readyFdEnv, "err", err)
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	defer f.Close()
+	if _, err := f.Write([]byte{1}); err != nil {
+		slog.Error("unable to tell the previous relay we are ready", "err", err)
+	}
+}
+
+// upgrade starts a copy of our binary, possibly upgraded on disk, with the same arguments, passing it our listeners,
+// and waits for it to start serving. On success, we stop accepting connections on the bind listeners, which are
+// served by the new relay, and the caller must shut the server down to drain the in-flight requests. It fails if the
+// new relay exits or doesn't start serving within upgradeTimeout, in which case we keep serving.
+func upgrade(bind []net.Listener, metrics net.Listener) error {
+	// we pass the file descriptors of the listeners as they are, since os/exec would set the sockets we share with
+	// the new relay to blocking mode, preventing us from closing our listeners
+	fds := []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd()}
+	var names []string
+	add := func(name string, l net.Listener) error {
+		sc, ok := l.(syscall.Conn)
+		if !ok {
+			return fmt.Errorf("unable to pass %s listener %s", name, l.Addr())
+		}
+		rc, err := sc.SyscallConn()
+		if err != nil {
+			return fmt.Errorf("unable to pass %s listener %s: %w", name, l.Addr(), err)
+		}
+		if err := rc.Control(func(fd uintptr) { fds = append(fds, fd) }); err != nil {
+			return fmt.Errorf("unable to pass %s listener %s: %w", name, l.Addr(), err)
+		}
+		names = append(names, name)
+		return nil
+	}
+	for _, l := range bind {
+		if err := add(bindListener, l); err != nil {
+			return err
+		}
+	}
+	if metrics != nil {
+		if err := add(metricsListener, metrics); err != nil {
+			return err
+		}
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	fds = append(fds, w.Fd())
+
+	// we don't use os.Executable, which points to our own binary even once it has been replaced
+	path, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		w.Close()
+		return fmt.Errorf("unable to find upgraded relay: %w", err)
+	}
+	pid, err := syscall.ForkExec(path, os.Args, &syscall.ProcAttr{
+		Env: append(os.Environ(),
+			listenersEnv+"="+strings.Join(names, ","),
+			readyFdEnv+"="+strconv.Itoa(listenFdsStart+len(names))),
+		Files: fds,
+	})
+	w.Close()
+	if err != nil {
+		return fmt.Errorf("unable to start upgraded relay: %w", err)
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	slog.Info("started upgraded relay, waiting for it to serve", "pid", pid)
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+	exited := make(chan error, 1)
+	go func() {
+		state, err := proc.Wait()
+		if err == nil {
+			err = errors.New(state.String())
+		}
+		exited <- err
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			// it closed the pipe without telling us it is ready, i.e. it exited
+			return fmt.Errorf("upgraded relay exited before serving: %w", <-exited)
+		}
+	case err := <-exited:
+		return fmt.Errorf("upgraded relay exited before serving: %w", err)
+	case <-time.After(upgradeTimeout):
+		proc.Kill()
+		return fmt.Errorf("upgraded relay didn't start serving within %s", upgradeTimeout)
+	}
+
+	release := func(l net.Listener) {
+		// the socket files must outlive our listeners
+		if ul, ok := l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+		// the sockets stay open in the new relay
+		l.Close()
+	}
+	for _, l := range bind {
+		release(l)
+	}
+	// the metrics are only served by the new relay too, so that scrapes don't alternate between our counters and its
+	if metrics != nil {
+		release(metrics)
+	}
+	time.Sleep(handoffGrace)
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// upgradeTestEnv tells the test binary started by upgrade how to behave as the upgraded relay.
+const upgradeTestEnv = "DRAND_RELAY_TEST_UPGRADE"
+
+func TestUpgrade(t *testing.T) {
+	switch os.Getenv(upgradeTestEnv) {
+	case "serve":
+		// we are the upgraded relay, serving a single request on the inherited listener
+		listeners, err := listen("")
+		require.NoError(t, err)
+		require.Len(t, listeners, 1)
+		served := make(chan struct{})
+		go http.Serve(listeners[0], http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Write([]byte("upgraded"))
+			close(served)
+		}))
+		signalReady()
+		select {
+		case <-served:
+		case <-time.After(10 * time.Second):
+			t.Error("no request served by the upgraded relay")
+		}
+		return
+	case "fail":
+		// we are an upgraded relay failing to start
+		return
+	}
+
+	// the upgraded relay is this test only, in a child process
+	setFlag(t, &os.Args, []string{os.Args[0], "-test.run=^TestUpgrade$"})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	// every request needs a new connection to know which relay accepts it
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	get := func() string {
+		resp, err := client.Get("http://" + l.Addr().String())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("original"))
+	})}
+	done := make(chan error, 1)
+	go func() { done <- serve(server, []net.Listener{l}) }()
+	require.Equal(t, "original", get())
+
+	metrics, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer metrics.Close()
+	metricsDone := make(chan error, 1)
+	go func() { metricsDone <- http.Serve(metrics, http.NotFoundHandler()) }()
+
+	// we keep serving when the upgraded relay fails
+	t.Setenv(upgradeTestEnv, "fail")
+	require.Error(t, upgrade([]net.Listener{l}, metrics))
+	require.Equal(t, "original", get())
+
+	// we stop accepting connections, including the metrics ones, once the upgraded relay serves them
+	t.Setenv(upgradeTestEnv, "serve")
+	require.NoError(t, upgrade([]net.Listener{l}, metrics))
+	require.ErrorIs(t, <-done, http.ErrServerClosed)
+	require.ErrorIs(t, <-metricsDone, net.ErrClosed)
+	require.Equal(t, "upgraded", get())
+}
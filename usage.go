@@ -0,0 +1,310 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The endpoints reported in usage reports, in addition to the opLatest, opNext and opRounds operations.
+const (
+	endpointInfo   = "info"
+	endpointHealth = "health"
+	endpointList   = "list"
+	endpointOther  = "other"
+)
+
+// noSubject is the subject recorded for the tokens without a sub claim.
+const noSubject = "-"
+
+// usage is recording the usage of the authenticated subjects when --usage-file is set.
+var usage *usageRecorder
+
+type usageKey struct {
+	Hour     time.Time `json:"hour"`
+	Subject  string    `json:"subject"`
+	Endpoint string    `json:"endpoint"`
+}
+
+type usageCounts struct {
+	Requests uint64 `json:"requests"`
+	Errors   uint64 `json:"errors"`
+	Bytes    uint64 `json:"bytes"`
+}
+
+type usageRecord struct {
+	usageKey
+	usageCounts
+}
+
+// usageRecorder counts the requests, failed requests and response bytes of each subject and endpoint per hour, and
+// persists them in a local file so that they survive restarts.
+type usageRecorder struct {
+	path      string
+	retention time.Duration
+	metrics   bool
+	now       func() time.Time
+
+	mu     sync.Mutex
+	counts map[usageKey]*usageCounts
+}
+
+// loadUsage returns a usageRecorder persisting its counters in path, starting from the ones already in it if any.
+func loadUsage(path string, retention time.Duration, metrics bool) (*usageRecorder, error) {
+	u := &usageRecorder{
+		path:      path,
+		retention: retention,
+		metrics:   metrics,
+		now:       time.Now,
+		counts:    make(map[usageKey]*usageCounts),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return u, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read usage file: %w", err)
+	}
+	var records []usageRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("invalid usage file %s: %w", path, err)
+	}
+	for _, r := range records {
+		c := r.usageCounts
+		u.counts[r.usageKey] = &c
+	}
+	return u, nil
+}
+
+// endpointOf returns the endpoint of a V2 API path, as reported in usage reports.
+func endpointOf(path string) string {
+	path = strings.TrimSuffix(path, "/")
+	parts := strings.Split(path, "/")
+	last := parts[len(parts)-1]
+	switch {
+	case path == "/v2/chains" || path == "/v2/beacons":
+		return endpointList
+	case last == "info":
+		return endpointInfo
+	case last == "health":
+		return endpointHealth
+	case last == "latest":
+		return opLatest
+	case last == "next":
+		return opNext
+	case len(parts) > 1 && parts[len(parts)-2] == "rounds":
+		return opRounds
+	}
+	return endpointOther
+}
+
+// record counts a request of the subject.
+func (u *usageRecorder) record(subject, endpoint string, status, bytes int) {
+	if subject == "" {
+		subject = noSubject
+	}
+	key := usageKey{Hour: u.now().UTC().Truncate(time.Hour), Subject: subject, Endpoint: endpoint}
+
+	u.mu.Lock()
+	c, ok := u.counts[key]
+	if !ok {
+		c = &usageCounts{}
+		u.counts[key] = c
+	}
+	c.Requests++
+	if status >= http.StatusBadRequest {
+		c.Errors++
+	}
+	c.Bytes += uint64(bytes)
+	u.mu.Unlock()
+
+	if u.metrics {
+		UsageRequests.With(prometheus.Labels{"subject": subject, "endpoint": endpoint}).Inc()
+		UsageBytes.With(prometheus.Labels{"subject": subject}).Add(float64(bytes))
+	}
+}
+
+// middleware records the usage of the requests authenticated by AddAuth.
+func (u *usageRecorder) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		subject := ""
+		if claims, ok := claimsFrom(r.Context()); ok {
+			subject = claims.Subject
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		u.record(subject, endpointOf(r.URL.Path), status, ww.BytesWritten())
+	})
+}
+
+// records returns the hourly records between from (inclusive) and to (exclusive), sorted by hour and subject.
+func (u *usageRecorder) records(from, to time.Time) []usageRecord {
+	u.mu.Lock()
+	records := make([]usageRecord, 0, len(u.counts))
+	for k, c := range u.counts {
+		if !k.Hour.Before(from) && k.Hour.Before(to) {
+			records = append(records, usageRecord{k, *c})
+		}
+	}
+	u.mu.Unlock()
+
+	slices.SortFunc(records, func(a, b usageRecord) int {
+		return cmp.Or(a.Hour.Compare(b.Hour), cmp.Compare(a.Subject, b.Subject), cmp.Compare(a.Endpoint, b.Endpoint))
+	})
+	return records
+}
+
+// flush writes the counters to the usage file, forgetting the ones older than the retention period.
+func (u *usageRecorder) flush() error {
+	if u.retention > 0 {
+		oldest := u.now().UTC().Add(-u.retention)
+		u.mu.Lock()
+		for k := range u.counts {
+			if k.Hour.Before(oldest) {
+				delete(u.counts, k)
+			}
+		}
+		u.mu.Unlock()
+	}
+
+	data, err := json.Marshal(u.records(time.Time{}, u.now().Add(time.Hour)))
+	if err != nil {
+		return err
+	}
+	// we write to a temporary file first, so that we never leave a truncated usage file behind
+	tmp, err := os.CreateTemp(filepath.Dir(u.path), filepath.Base(u.path)+".*")
+	if err != nil {
+		return fmt.Errorf("unable to write usage file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("unable to write usage file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("unable to write usage file: %w", err)
+	}
+	return os.Rename(tmp.Name(), u.path)
+}
+
+// run flushes the counters every interval until the context is cancelled.
+func (u *usageRecorder) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := u.flush(); err != nil {
+				slog.Error("unable to persist usage counters", "err", err)
+			}
+		}
+	}
+}
+
+// start runs the recorder in the background until the returned function is called, which waits for it to stop so
+// that the counters are not flushed anymore once it returns.
+func (u *usageRecorder) start(ctx context.Context, interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		u.run(ctx, interval)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// usageReport is the usage of a subject on an endpoint during the report window.
+type usageReport struct {
+	Subject  string `json:"subject"`
+	Endpoint string `json:"endpoint"`
+	usageCounts
+}
+
+// report sums the usage of each subject and endpoint between from (inclusive) and to (exclusive).
+func (u *usageRecorder) report(from, to time.Time) []usageReport {
+	reports := make([]usageReport, 0)
+	index := make(map[[2]string]int)
+	for _, r := range u.records(from, to) {
+		k := [2]string{r.Subject, r.Endpoint}
+		i, ok := index[k]
+		if !ok {
+			i = len(reports)
+			index[k] = i
+			reports = append(reports, usageReport{Subject: r.Subject, Endpoint: r.Endpoint})
+		}
+		reports[i].Requests += r.Requests
+		reports[i].Errors += r.Errors
+		reports[i].Bytes += r.Bytes
+	}
+	slices.SortFunc(reports, func(a, b usageReport) int {
+		return cmp.Or(cmp.Compare(a.Subject, b.Subject), cmp.Compare(a.Endpoint, b.Endpoint))
+	})
+	return reports
+}
+
+// ServeHTTP exports the usage report of the window set by the from and to RFC 3339 query parameters, defaulting to
+// the last 30 days, as JSON or as CSV with format=csv. Usage is counted per hour, so the window is too.
+func (u *usageRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	to := u.now()
+	from := to.AddDate(0, 0, -30)
+	for name, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := r.URL.Query().Get(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid %s parameter, expected an RFC 3339 time", name), http.StatusBadRequest)
+				return
+			}
+			*t = parsed
+		}
+	}
+	reports := u.report(from, to)
+
+	switch r.URL.Query().Get("format") {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"from", "to", "subject", "endpoint", "requests", "errors", "bytes"})
+		for _, rep := range reports {
+			cw.Write([]string{
+				from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339), rep.Subject, rep.Endpoint,
+				strconv.FormatUint(rep.Requests, 10), strconv.FormatUint(rep.Errors, 10), strconv.FormatUint(rep.Bytes, 10),
+			})
+		}
+		cw.Flush()
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"from":  from.UTC().Format(time.RFC3339),
+			"to":    to.UTC().Format(time.RFC3339),
+			"usage": reports,
+		})
+	default:
+		http.Error(w, "Invalid format parameter, expected csv or json", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndpointOf(t *testing.T) {
+	for path, endpoint := range map[string]string{
+		"/v2/chains":                          endpointList,
+		"/v2/beacons/":                        endpointList,
+		"/v2/beacons/quicknet/info":           endpointInfo,
+		"/v2/chains/abcd/health":              endpointHealth,
+		"/v2/beacons/quicknet/rounds/latest":  opLatest,
+		"/v2/beacons/quicknet/rounds/next":    opNext,
+		"/v2/beacons/quicknet/rounds/1234":    opRounds,
+		"/v2/chains/abcd/rounds/1234":         opRounds,
+		"/v2/beacons/quicknet/something-else": endpointOther,
+	} {
+		require.Equal(t, endpoint, endpointOf(path), path)
+	}
+}
+
+func TestUsageRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	u, err := loadUsage(path, 48*time.Hour, false)
+	require.NoError(t, err)
+	now := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+	u.now = func() time.Time { return now }
+
+	handler := u.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/beacons/quicknet/rounds/0" {
+			http.Error(w, "nope", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("0123456789"))
+	}))
+	get := func(path, subject string) {
+		req := withClaims(httptest.NewRequest(http.MethodGet, path, nil), &scopeClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: subject}})
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	get("/v2/beacons/quicknet/rounds/latest", "a")
+	get("/v2/beacons/quicknet/rounds/latest", "a")
+	get("/v2/beacons/quicknet/rounds/0", "a")
+	get("/v2/beacons/quicknet/info", "")
+	now = now.Add(time.Hour)
+	get("/v2/beacons/quicknet/rounds/latest", "a")
+
+	reports := u.report(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), now.Add(time.Hour))
+	require.Equal(t, []usageReport{
+		{Subject: noSubject, Endpoint: endpointInfo, usageCounts: usageCounts{Requests: 1, Bytes: 10}},
+		{Subject: "a", Endpoint: opLatest, usageCounts: usageCounts{Requests: 3, Bytes: 30}},
+		{Subject: "a", Endpoint: opRounds, usageCounts: usageCounts{Requests: 1, Errors: 1, Bytes: 5}},
+	}, reports)
+
+	// the report window is hourly
+	reports = u.report(time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC), now.Add(time.Hour))
+	require.Equal(t, []usageReport{{Subject: "a", Endpoint: opLatest, usageCounts: usageCounts{Requests: 1, Bytes: 10}}}, reports)
+
+	// the counters survive restarts, except for the ones older than the retention period
+	require.NoError(t, u.flush())
+	reloaded, err := loadUsage(path, 48*time.Hour, false)
+	require.NoError(t, err)
+	require.Equal(t, u.counts, reloaded.counts)
+
+	now = now.Add(47 * time.Hour)
+	require.NoError(t, u.flush())
+	reloaded, err = loadUsage(path, 48*time.Hour, false)
+	require.NoError(t, err)
+	require.Len(t, reloaded.counts, 1)
+}
+
+func TestUsageRecorder_Stop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	u, err := loadUsage(path, 0, false)
+	require.NoError(t, err)
+
+	stop := u.start(context.Background(), time.Millisecond)
+	u.record("a", opLatest, http.StatusOK, 10)
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}, time.Second, time.Millisecond)
+
+	// once handed off, the usage file belongs to the upgraded relay and is never written again
+	stop()
+	require.NoError(t, os.Remove(path))
+	u.record("a", opLatest, http.StatusOK, 10)
+	time.Sleep(20 * time.Millisecond)
+	require.NoFileExists(t, path)
+}
+
+func TestUsageReportExport(t *testing.T) {
+	u, err := loadUsage(filepath.Join(t.TempDir(), "usage.json"), 0, false)
+	require.NoError(t, err)
+	u.now = func() time.Time { return time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC) }
+	u.record("a", opLatest, http.StatusOK, 100)
+	u.record("b", opNext, http.StatusTooManyRequests, 20)
+
+	rr := httptest.NewRecorder()
+	u.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/usage?from=2024-05-01T00:00:00Z&to=2024-05-02T00:00:00Z&format=csv", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	rows, err := csv.NewReader(rr.Body).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"from", "to", "subject", "endpoint", "requests", "errors", "bytes"},
+		{"2024-05-01T00:00:00Z", "2024-05-02T00:00:00Z", "a", opLatest, "1", "0", "100"},
+		{"2024-05-01T00:00:00Z", "2024-05-02T00:00:00Z", "b", opNext, "1", "1", "20"},
+	}, rows)
+
+	rr = httptest.NewRecorder()
+	u.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/usage?from=2024-05-02T00:00:00Z&to=2024-05-03T00:00:00Z", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var report struct {
+		Usage []usageReport `json:"usage"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	require.Empty(t, report.Usage)
+
+	rr = httptest.NewRecorder()
+	u.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/usage?from=yesterday", nil))
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+}